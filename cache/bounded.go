@@ -0,0 +1,241 @@
+package cache
+
+import (
+	"io"
+	"sync"
+)
+
+// 다른 Cache를 감싸서 전체 크기와 개수를 제한. 넘치면 Policy에 따라 캐시를 지움
+type BoundedCache struct {
+	Backend  Cache
+	Policy   EvictionPolicy
+	MaxBytes int64 // 0이면 제한 없음
+	MaxItems int   // 0이면 제한 없음
+	OnEvict  func(cd CacheData)
+
+	mutex     sync.Mutex
+	entries   map[string]boundedEntry
+	usedBytes int64
+	evictions int64
+}
+
+type boundedEntry struct {
+	hashKey    int
+	size       int64
+	url        string
+	primaryKey string
+}
+
+type CacheStats struct {
+	UsedBytes int64
+	Items     int
+	Evictions int64
+	MaxBytes  int64
+	MaxItems  int
+}
+
+func (bc *BoundedCache) Init() {
+	bc.Backend.Init()
+	bc.entries = map[string]boundedEntry{}
+
+	// 재시작 후에도 남아있는 캐시를 다시 계산. 그 사이 줄어든 제한을 넘는 캐시는 지움
+	for _, cd := range bc.Backend.GetAll() {
+		for _, evicted := range bc.add(cd.HashKey, cd.Sha256, cd.Ci) {
+			bc.Backend.Del(evicted.HashKey, evicted.Sha256)
+		}
+	}
+}
+
+func (bc *BoundedCache) Close() {
+	bc.Backend.Close()
+}
+
+func (bc *BoundedCache) Clear() { //For Test
+	bc.Backend.Clear()
+
+	bc.mutex.Lock()
+	defer bc.mutex.Unlock()
+	for sha256 := range bc.entries {
+		bc.Policy.Remove(sha256)
+	}
+	bc.entries = map[string]boundedEntry{}
+	bc.usedBytes = 0
+}
+
+func (bc *BoundedCache) Get(hashKey int, sha256 string) (ci CacheItem, exist bool) {
+	ci, exist = bc.Backend.Get(hashKey, sha256)
+	if exist {
+		bc.mutex.Lock()
+		bc.Policy.Access(sha256)
+		bc.mutex.Unlock()
+	}
+	return ci, exist
+}
+
+// 사용한 것으로 치지 않음
+func (bc *BoundedCache) Exists(hashKey int, sha256 string) bool {
+	return bc.Backend.Exists(hashKey, sha256)
+}
+
+func (bc *BoundedCache) GetAll() (ciList []CacheData) {
+	return bc.Backend.GetAll()
+}
+
+func (bc *BoundedCache) Open(hashKey int, sha256 string) (ci CacheItem, body io.ReadCloser, exist bool) {
+	ci, body, exist = bc.Backend.Open(hashKey, sha256)
+	if exist {
+		bc.mutex.Lock()
+		bc.Policy.Access(sha256)
+		bc.mutex.Unlock()
+	}
+	return ci, body, exist
+}
+
+func (bc *BoundedCache) OpenEncoded(hashKey int, sha256 string, encoding string) (body io.ReadCloser, exist bool) {
+	return bc.Backend.OpenEncoded(hashKey, sha256, encoding)
+}
+
+func (bc *BoundedCache) Set(hashKey int, sha256 string, ci CacheItem) {
+	bc.Backend.Set(hashKey, sha256, ci)
+	bc.evict(bc.add(hashKey, sha256, ci))
+}
+
+// 본문 크기는 바뀌지 않으므로 다시 계산하지 않음
+func (bc *BoundedCache) UpdateMeta(hashKey int, sha256 string, update func(ci *CacheItem)) bool {
+	return bc.Backend.UpdateMeta(hashKey, sha256, update)
+}
+
+func (bc *BoundedCache) NewWriter(hashKey int, sha256 string, ci CacheItem) (CacheWriter, error) {
+	cw, err := bc.Backend.NewWriter(hashKey, sha256, ci)
+	if err != nil {
+		return nil, err
+	}
+	return &boundedWriter{bc: bc, CacheWriter: cw, hashKey: hashKey, sha256: sha256, ci: ci}, nil
+}
+
+// 저장이 끝난 후에 크기를 기록
+type boundedWriter struct {
+	CacheWriter
+	bc      *BoundedCache
+	hashKey int
+	sha256  string
+	ci      CacheItem
+	size    int64
+	encoded map[string]*countWriter
+}
+
+type countWriter struct {
+	w    io.Writer
+	size int64
+}
+
+func (cw *countWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.size += int64(n)
+	return n, err
+}
+
+func (bw *boundedWriter) Write(p []byte) (int, error) {
+	n, err := bw.CacheWriter.Write(p)
+	bw.size += int64(n)
+	return n, err
+}
+
+func (bw *boundedWriter) Encoded(encoding string) io.Writer {
+	if bw.encoded == nil {
+		bw.encoded = map[string]*countWriter{}
+	}
+	if bw.encoded[encoding] == nil {
+		bw.encoded[encoding] = &countWriter{w: bw.CacheWriter.Encoded(encoding)}
+	}
+	return bw.encoded[encoding]
+}
+
+func (bw *boundedWriter) Commit() error {
+	err := bw.CacheWriter.Commit()
+	if err != nil {
+		return err
+	}
+	ci := bw.ci
+	ci.Body, ci.EncodedBodies = nil, nil
+	ci.Size = bw.size
+	ci.EncodedSizes = map[string]int64{}
+	for encoding, cw := range bw.encoded {
+		ci.EncodedSizes[encoding] = cw.size
+	}
+	bw.bc.evict(bw.bc.add(bw.hashKey, bw.sha256, ci))
+	return nil
+}
+
+func (bc *BoundedCache) evict(evicted []CacheData) {
+	for _, cd := range evicted {
+		bc.Backend.Del(cd.HashKey, cd.Sha256)
+		if bc.OnEvict != nil {
+			bc.OnEvict(cd)
+		}
+	}
+}
+
+func (bc *BoundedCache) Del(hashKey int, sha256 string) {
+	bc.Backend.Del(hashKey, sha256)
+
+	bc.mutex.Lock()
+	defer bc.mutex.Unlock()
+	bc.remove(sha256)
+}
+
+func (bc *BoundedCache) SetLimits(maxBytes int64, maxItems int) {
+	bc.mutex.Lock()
+	defer bc.mutex.Unlock()
+	bc.MaxBytes, bc.MaxItems = maxBytes, maxItems
+}
+
+func (bc *BoundedCache) maxBytes() int64 {
+	bc.mutex.Lock()
+	defer bc.mutex.Unlock()
+	return bc.MaxBytes
+}
+
+func (bc *BoundedCache) Stats() CacheStats {
+	bc.mutex.Lock()
+	defer bc.mutex.Unlock()
+	return CacheStats{bc.usedBytes, len(bc.entries), bc.evictions, bc.MaxBytes, bc.MaxItems}
+}
+
+// 크기를 기록하고, 제한을 넘으면 지울 캐시들을 골라서 돌려줌
+func (bc *BoundedCache) add(hashKey int, sha256 string, ci CacheItem) (evicted []CacheData) {
+	bc.mutex.Lock()
+	defer bc.mutex.Unlock()
+
+	bc.remove(sha256)
+	ci.setSizes()
+	size := ci.storedSize()
+	bc.entries[sha256] = boundedEntry{hashKey, size, ci.URL, ci.PrimaryKey}
+	bc.usedBytes += size
+	bc.Policy.Add(sha256, size)
+
+	for bc.isOverLimit() {
+		victim, ok := bc.Policy.Evict()
+		if !ok {
+			break
+		}
+		entry := bc.entries[victim]
+		delete(bc.entries, victim)
+		bc.usedBytes -= entry.size
+		bc.evictions++
+		evicted = append(evicted, CacheData{entry.hashKey, victim, CacheItem{URL: entry.url, PrimaryKey: entry.primaryKey}})
+	}
+	return evicted
+}
+
+func (bc *BoundedCache) remove(sha256 string) {
+	if entry, exist := bc.entries[sha256]; exist {
+		delete(bc.entries, sha256)
+		bc.usedBytes -= entry.size
+		bc.Policy.Remove(sha256)
+	}
+}
+
+func (bc *BoundedCache) isOverLimit() bool {
+	return (bc.MaxBytes > 0 && bc.usedBytes > bc.MaxBytes) || (bc.MaxItems > 0 && len(bc.entries) > bc.MaxItems)
+}
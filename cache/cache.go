@@ -2,9 +2,10 @@ package cache
 
 import (
 	"encoding/json"
+	"fmt"
+	"io"
 	"net/http"
 	"os"
-	"path/filepath"
 	"strconv"
 	"sync"
 	"time"
@@ -17,9 +18,20 @@ type Cache interface {
 	Close()
 	Clear() //For Test
 	Get(hashKey int, sha256 string) (ci CacheItem, exist bool)
+	// 본문을 읽지 않고 캐시가 있는지만 확인
+	Exists(hashKey int, sha256 string) bool
 	GetAll() (ciList []CacheData)
 	Set(hashKey int, sha256 string, ci CacheItem)
+	// 본문은 그대로 두고 저장된 헤더, 유효시간 등을 update로 바꿈. 캐시가 없으면 false
+	UpdateMeta(hashKey int, sha256 string, update func(ci *CacheItem)) bool
 	Del(hashKey int, sha256 string)
+	// 본문을 한번에 읽지 않는 조회. ci.Body는 비어 있고 본문은 body로 읽음.
+	// 압축된 본문을 메모리에 두는 Cache는 ci.EncodedBodies도 채움
+	Open(hashKey int, sha256 string) (ci CacheItem, body io.ReadCloser, exist bool)
+	// encoding으로 미리 압축해서 저장한 본문
+	OpenEncoded(hashKey int, sha256 string, encoding string) (body io.ReadCloser, exist bool)
+	// 본문을 받는 대로 저장. Commit을 호출해야 캐시에 추가됨
+	NewWriter(hashKey int, sha256 string, ci CacheItem) (CacheWriter, error)
 }
 
 type RedisCache struct {
@@ -27,7 +39,9 @@ type RedisCache struct {
 }
 
 type FileCache struct {
-	SciList []*SafeCacheItem
+	SciList     []*SafeCacheItem
+	Dirs        []string                // 시작할 때 캐시 목록을 다시 읽을 디렉토리
+	IsRemovable func(ci CacheItem) bool // 다시 읽은 캐시를 버릴지 여부. nil이면 만료된 캐시를 버림
 }
 
 type SafeCacheItem struct {
@@ -43,6 +57,43 @@ type CacheItem struct {
 	Filepath       string
 	ExpirationTime time.Time
 	CachedTime     time.Time
+	InitialAge     time.Duration // 저장할 때 이미 지나 있던 Age
+	Vary           []string      // Origin 응답의 Vary 헤더 이름들
+	PrimaryKey     string        // Vary가 있는 경우 variant들이 공유하는 URL의 sha256
+	Size           int64         // 본문 크기. FileCache의 목록처럼 Body 없이 읽은 경우에도 사용
+
+	EncodedSizes  map[string]int64  // 미리 압축해서 함께 저장한 본문의 Content-Encoding별 크기
+	EncodedBodies map[string][]byte // 압축된 본문. 본문을 메모리나 Redis에 두는 Cache에서 사용
+}
+
+// 본문으로 Size, EncodedSizes를 채움. 본문 없이 크기만 있는 경우는 그대로 둠
+func (ci *CacheItem) setSizes() {
+	if ci.Body != nil {
+		ci.Size = int64(len(ci.Body))
+	}
+	if len(ci.EncodedBodies) == 0 {
+		return
+	}
+	encodedSizes := make(map[string]int64, len(ci.EncodedBodies))
+	for encoding, size := range ci.EncodedSizes {
+		encodedSizes[encoding] = size
+	}
+	for encoding, body := range ci.EncodedBodies {
+		encodedSizes[encoding] = int64(len(body))
+	}
+	ci.EncodedSizes = encodedSizes
+}
+
+// 압축된 본문까지 포함한 저장 크기
+func (ci CacheItem) storedSize() int64 {
+	size := ci.Size
+	if ci.Body != nil {
+		size = int64(len(ci.Body))
+	}
+	for _, encodedSize := range ci.EncodedSizes {
+		size += encodedSize
+	}
+	return size
 }
 
 type CacheData struct {
@@ -56,6 +107,14 @@ func (fc *FileCache) Clear() { //For Test
 	os.RemoveAll(wcsPath + "log_body")
 	os.RemoveAll(wcsPath + "log_image")
 	os.Remove(wcsPath + "log_file.txt")
+	for _, dir := range fc.Dirs {
+		os.RemoveAll(dir)
+	}
+	for _, sci := range fc.SciList {
+		sci.RW.Lock()
+		sci.CiMap = make(map[string]CacheItem)
+		sci.RW.Unlock()
+	}
 }
 
 func (fc *FileCache) Close() {}
@@ -68,26 +127,48 @@ func (fc *FileCache) Init() {
 		}
 		fc.SciList = append(fc.SciList, sci)
 	}
+	fc.loadIndex()
 }
 
 func (fc *FileCache) Get(hashKey int, sha256 string) (ci CacheItem, exist bool) {
 	sci := fc.SciList[hashKey]
 	sci.RW.RLock()
-	defer sci.RW.RUnlock()
-
 	ci, exist = sci.CiMap[sha256]
-
+	var err error
 	if exist {
-		filebody, err := os.ReadFile(ci.Filepath)
-		if err != nil {
-			panic(err)
-		}
-		ci.Body = filebody
+		ci.Body, err = os.ReadFile(ci.Filepath)
 	}
+	sci.RW.RUnlock()
 
+	if err != nil {
+		fc.dropUnreadable(hashKey, sha256, ci, err)
+		return CacheItem{}, false
+	}
 	return ci, exist
 }
 
+// 본문 파일을 읽지 못하는 캐시(밖에서 파일이 지워진 경우 등)는 목록에서 지우고 없는 캐시로 봄
+func (fc *FileCache) dropUnreadable(hashKey int, sha256 string, ci CacheItem, err error) {
+	fmt.Printf("Discard cache (%v) : %s\n", err, ci.Filepath)
+	sci := fc.SciList[hashKey]
+	sci.RW.Lock()
+	defer sci.RW.Unlock()
+
+	// 그 사이 다시 저장된 캐시는 그대로 둠
+	if stored, exist := sci.CiMap[sha256]; exist && stored.CachedTime.Equal(ci.CachedTime) {
+		removeEntry(stored)
+		delete(sci.CiMap, sha256)
+	}
+}
+
+func (fc *FileCache) Exists(hashKey int, sha256 string) bool {
+	sci := fc.SciList[hashKey]
+	sci.RW.RLock()
+	defer sci.RW.RUnlock()
+	_, exist := sci.CiMap[sha256]
+	return exist
+}
+
 func (fc *FileCache) GetAll() (cacheDataList []CacheData) {
 	for hashKey, sci := range fc.SciList {
 		sci.RW.RLock()
@@ -105,22 +186,47 @@ func (fc *FileCache) Set(hashKey int, sha256 string, ci CacheItem) {
 	sci.RW.Lock()
 	defer sci.RW.Unlock()
 
-	filePath := ci.Filepath
-	os.MkdirAll(filepath.Dir(filePath), os.ModePerm)
-	os.WriteFile(filePath, ci.Body, 0644)
+	ci.setSizes()
+	err := writeEntry(hashKey, sha256, ci)
+	if err != nil {
+		// 일부만 바뀐 파일을 이전 메타데이터로 읽지 않도록 이전 캐시도 지움
+		fmt.Printf("Cache save error (%v) : %s\n", err, ci.Filepath)
+		removeEntry(ci)
+		delete(sci.CiMap, sha256)
+		return
+	}
+	ci.Body, ci.EncodedBodies = nil, nil // 본문은 파일에서 읽음
 	sci.CiMap[sha256] = ci
 }
 
+func (fc *FileCache) UpdateMeta(hashKey int, sha256 string, update func(ci *CacheItem)) bool {
+	sci := fc.SciList[hashKey]
+	sci.RW.Lock()
+	defer sci.RW.Unlock()
+
+	ci, exist := sci.CiMap[sha256]
+	if !exist {
+		return false
+	}
+	update(&ci)
+	err := writeMeta(hashKey, sha256, ci)
+	if err != nil {
+		fmt.Printf("Cache meta save error (%v) : %s\n", err, ci.Filepath)
+		return false
+	}
+	sci.CiMap[sha256] = ci
+	return true
+}
+
 func (fc *FileCache) Del(hashKey int, sha256 string) {
 	sci := fc.SciList[hashKey]
 	sci.RW.Lock()
 	defer sci.RW.Unlock()
 
-	_, exist := sci.CiMap[sha256]
+	ci, exist := sci.CiMap[sha256]
 	if exist {
-		err := os.Remove(sci.CiMap[sha256].Filepath)
-		if err != nil {
-			panic(err)
+		if err := removeEntry(ci); err != nil {
+			fmt.Printf("Cache remove error (%v) : %s\n", err, ci.Filepath)
 		}
 		delete(sci.CiMap, sha256)
 	}
@@ -151,19 +257,23 @@ func (rc *RedisCache) Close() {
 }
 
 func (rc *RedisCache) Get(hashKey int, sha256 string) (ci CacheItem, exist bool) {
-	exist, err := rc.RedisClient.HExists(strconv.Itoa(hashKey), sha256).Result()
+	ciJSON, err := rc.RedisClient.HGet(strconv.Itoa(hashKey), sha256).Result()
+	if err == redis.Nil {
+		return ci, false
+	}
 	if err != nil {
 		panic(err)
 	}
-	if exist {
-		ciJSON, err := rc.RedisClient.HGet(strconv.Itoa(hashKey), sha256).Result()
-		if err != nil {
-			panic(err)
-		}
-		json.Unmarshal([]byte(ciJSON), &ci)
-	}
+	json.Unmarshal([]byte(ciJSON), &ci)
+	return ci, true
+}
 
-	return ci, exist
+func (rc *RedisCache) Exists(hashKey int, sha256 string) bool {
+	exist, err := rc.RedisClient.HExists(strconv.Itoa(hashKey), sha256).Result()
+	if err != nil {
+		panic(err)
+	}
+	return exist
 }
 
 func (rc *RedisCache) GetAll() (ciList []CacheData) {
@@ -184,6 +294,7 @@ func (rc *RedisCache) GetAll() (ciList []CacheData) {
 }
 
 func (rc *RedisCache) Set(hashKey int, sha256 string, ci CacheItem) {
+	ci.setSizes()
 	ciJSON, _ := json.Marshal(ci)
 	err := rc.RedisClient.HSet(strconv.Itoa(hashKey), sha256, ciJSON).Err()
 	if err != nil {
@@ -191,6 +302,17 @@ func (rc *RedisCache) Set(hashKey int, sha256 string, ci CacheItem) {
 	}
 }
 
+// 압축된 본문도 같은 값에 들어 있으므로 읽은 그대로 다시 저장
+func (rc *RedisCache) UpdateMeta(hashKey int, sha256 string, update func(ci *CacheItem)) bool {
+	ci, exist := rc.Get(hashKey, sha256)
+	if !exist {
+		return false
+	}
+	update(&ci)
+	rc.Set(hashKey, sha256, ci)
+	return true
+}
+
 func (rc *RedisCache) Del(hashKey int, sha256 string) {
 	_, err := rc.RedisClient.HDel(strconv.Itoa(hashKey), sha256).Result() //_ : 지워진 값 개수
 	if err != nil {
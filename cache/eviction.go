@@ -0,0 +1,136 @@
+package cache
+
+import (
+	"container/heap"
+	"fmt"
+)
+
+const (
+	POLICY_LRU  string = "lru"
+	POLICY_LFU  string = "lfu"
+	POLICY_GDSF string = "gdsf"
+)
+
+// 용량을 넘었을 때 어떤 캐시를 먼저 지울지 결정
+type EvictionPolicy interface {
+	Add(key string, size int64)
+	Access(key string)
+	Remove(key string)
+	Evict() (key string, ok bool) // 지울 key를 골라 policy에서 제거
+}
+
+func NewEvictionPolicy(name string) (EvictionPolicy, error) {
+	switch name {
+	case POLICY_LRU:
+		return newHeapPolicy(func(hp *heapPolicy, pe *policyEntry) float64 {
+			return float64(pe.lastAccess)
+		}), nil
+	case POLICY_LFU:
+		return newHeapPolicy(func(hp *heapPolicy, pe *policyEntry) float64 {
+			return float64(pe.hits)
+		}), nil
+	case POLICY_GDSF:
+		// Greedy-Dual-Size-Frequency : 작고 자주 쓰이는 캐시를 남김. 비용은 1로 계산
+		return newHeapPolicy(func(hp *heapPolicy, pe *policyEntry) float64 {
+			return hp.inflation + float64(pe.hits)/float64(max(pe.size, 1))
+		}), nil
+	}
+	return nil, fmt.Errorf("unknown eviction policy %q", name)
+}
+
+type policyEntry struct {
+	key        string
+	size       int64
+	hits       int64
+	lastAccess int64
+	priority   float64
+	index      int
+}
+
+// priority가 가장 낮은 캐시부터 지움. priority가 같으면 오래 쓰이지 않은 캐시부터
+type heapPolicy struct {
+	entries   map[string]*policyEntry
+	queue     policyQueue
+	clock     int64
+	inflation float64
+	priority  func(hp *heapPolicy, pe *policyEntry) float64
+}
+
+func newHeapPolicy(priority func(hp *heapPolicy, pe *policyEntry) float64) *heapPolicy {
+	return &heapPolicy{entries: map[string]*policyEntry{}, priority: priority}
+}
+
+func (hp *heapPolicy) Add(key string, size int64) {
+	if pe, exist := hp.entries[key]; exist {
+		pe.size = size
+		hp.touch(pe)
+		return
+	}
+	pe := &policyEntry{key: key, size: size}
+	hp.entries[key] = pe
+	hp.clock++
+	pe.hits, pe.lastAccess = 1, hp.clock
+	pe.priority = hp.priority(hp, pe)
+	heap.Push(&hp.queue, pe)
+}
+
+func (hp *heapPolicy) Access(key string) {
+	if pe, exist := hp.entries[key]; exist {
+		hp.touch(pe)
+	}
+}
+
+func (hp *heapPolicy) touch(pe *policyEntry) {
+	hp.clock++
+	pe.hits++
+	pe.lastAccess = hp.clock
+	pe.priority = hp.priority(hp, pe)
+	heap.Fix(&hp.queue, pe.index)
+}
+
+func (hp *heapPolicy) Remove(key string) {
+	if pe, exist := hp.entries[key]; exist {
+		heap.Remove(&hp.queue, pe.index)
+		delete(hp.entries, key)
+	}
+}
+
+func (hp *heapPolicy) Evict() (string, bool) {
+	if len(hp.queue) == 0 {
+		return "", false
+	}
+	pe := heap.Pop(&hp.queue).(*policyEntry)
+	delete(hp.entries, pe.key)
+	hp.inflation = pe.priority
+	return pe.key, true
+}
+
+type policyQueue []*policyEntry
+
+func (pq policyQueue) Len() int { return len(pq) }
+
+func (pq policyQueue) Less(i, j int) bool {
+	if pq[i].priority != pq[j].priority {
+		return pq[i].priority < pq[j].priority
+	}
+	return pq[i].lastAccess < pq[j].lastAccess
+}
+
+func (pq policyQueue) Swap(i, j int) {
+	pq[i], pq[j] = pq[j], pq[i]
+	pq[i].index = i
+	pq[j].index = j
+}
+
+func (pq *policyQueue) Push(x any) {
+	pe := x.(*policyEntry)
+	pe.index = len(*pq)
+	*pq = append(*pq, pe)
+}
+
+func (pq *policyQueue) Pop() any {
+	old := *pq
+	pe := old[len(old)-1]
+	*pq = old[:len(old)-1]
+	return pe
+}
@@ -0,0 +1,107 @@
+package cache_test
+
+import (
+	"jnlee/cache"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func evictAll(policy cache.EvictionPolicy) (keys []string) {
+	for {
+		key, ok := policy.Evict()
+		if !ok {
+			return keys
+		}
+		keys = append(keys, key)
+	}
+}
+
+func TestEvictionPolicy(t *testing.T) {
+	// a : 작고 한번 사용, b : 크고 세번 사용, c : 작고 두번 사용
+	tests := []struct {
+		policy string
+		want   string
+	}{
+		{cache.POLICY_LRU, "a"},
+		{cache.POLICY_LFU, "a"},
+		{cache.POLICY_GDSF, "b"},
+	}
+	for _, test := range tests {
+		policy, err := cache.NewEvictionPolicy(test.policy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		policy.Add("a", 10)
+		policy.Add("b", 10000)
+		policy.Add("c", 10)
+		policy.Access("b")
+		policy.Access("b")
+		policy.Access("c")
+
+		keys := evictAll(policy)
+		if len(keys) != 3 || keys[0] != test.want {
+			t.Errorf("%s : evicted %v, want %s first", test.policy, keys, test.want)
+		}
+	}
+
+	policy, _ := cache.NewEvictionPolicy(cache.POLICY_LRU)
+	policy.Add("a", 1)
+	policy.Add("b", 1)
+	policy.Access("a")
+	policy.Remove("b")
+	if keys := evictAll(policy); len(keys) != 1 || keys[0] != "a" {
+		t.Errorf("evicted %v after remove, want [a]", keys)
+	}
+
+	if _, err := cache.NewEvictionPolicy("fifo"); err == nil {
+		t.Error("unknown policy must return error")
+	}
+}
+
+func TestBoundedCache(t *testing.T) {
+	dir := t.TempDir()
+	policy, _ := cache.NewEvictionPolicy(cache.POLICY_LRU)
+	var evicted []string
+	bc := &cache.BoundedCache{
+		Backend:  &cache.FileCache{},
+		Policy:   policy,
+		MaxBytes: 25,
+		OnEvict:  func(cd cache.CacheData) { evicted = append(evicted, cd.Ci.URL) },
+	}
+	bc.Init()
+
+	set := func(i int) {
+		bc.Set(i, "sha_"+strconv.Itoa(i), cache.CacheItem{
+			Body:     []byte("0123456789"),
+			URL:      "url_" + strconv.Itoa(i),
+			Filepath: filepath.Join(dir, strconv.Itoa(i)),
+		})
+	}
+	set(0)
+	set(1)
+	bc.Get(0, "sha_0") // 1이 가장 오래 사용되지 않은 캐시
+	set(2)
+
+	if len(evicted) != 1 || evicted[0] != "url_1" {
+		t.Errorf("evicted %v, want [url_1]", evicted)
+	}
+	if _, exist := bc.Get(1, "sha_1"); exist {
+		t.Error("evicted item must be deleted from backend")
+	}
+	stats := bc.Stats()
+	if stats.Items != 2 || stats.UsedBytes != 20 || stats.Evictions != 1 {
+		t.Errorf("stats %+v", stats)
+	}
+
+	bc.SetLimits(0, 1)
+	set(3)
+	if stats := bc.Stats(); stats.Items != 1 || stats.Evictions != 3 {
+		t.Errorf("stats %+v after SetLimits", stats)
+	}
+
+	bc.Del(3, "sha_3")
+	if stats := bc.Stats(); stats.Items != 0 || stats.UsedBytes != 0 {
+		t.Errorf("stats %+v after Del", stats)
+	}
+}
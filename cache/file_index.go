@@ -0,0 +1,160 @@
+package cache
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// 본문 파일 옆에 저장하는 메타데이터 파일의 확장자
+const META_EXT string = ".meta"
+
+// 메타데이터 파일의 내용. 본문은 따로 저장하므로 Ci.Body는 비어 있음
+type fileEntry struct {
+	HashKey int
+	Sha256  string
+	Ci      CacheItem
+}
+
+// 본문을 먼저 쓰고 메타데이터를 나중에 씀. 메타데이터가 있으면 본문도 모두 쓰여 있음
+func writeEntry(hashKey int, sha256 string, ci CacheItem) error {
+	err := os.MkdirAll(filepath.Dir(ci.Filepath), os.ModePerm)
+	if err != nil {
+		return err
+	}
+	for encoding, body := range ci.EncodedBodies {
+		err = writeFileAtomic(encodedPath(ci.Filepath, encoding), body)
+		if err != nil {
+			return err
+		}
+	}
+	err = writeFileAtomic(ci.Filepath, ci.Body)
+	if err != nil {
+		return err
+	}
+	return writeMeta(hashKey, sha256, ci)
+}
+
+func writeMeta(hashKey int, sha256 string, ci CacheItem) error {
+	ci.Body, ci.EncodedBodies = nil, nil
+	metaJSON, err := json.Marshal(fileEntry{hashKey, sha256, ci})
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(ci.Filepath+META_EXT, metaJSON)
+}
+
+// 쓰는 도중에 종료되어도 반쯤 쓰인 파일이 남지 않도록 임시 파일에 쓴 뒤 이름을 바꿈
+func writeFileAtomic(path string, data []byte) error {
+	tmpPath := path + ".tmp"
+	err := os.WriteFile(tmpPath, data, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// 압축된 본문은 본문 파일 이름 뒤에 Content-Encoding을 붙여서 저장
+func encodedPath(path string, encoding string) string {
+	return path + "." + encoding
+}
+
+// 메타데이터를 먼저 지워서 본문만 남더라도 다시 읽지 않도록 함
+func removeEntry(ci CacheItem) error {
+	err := os.Remove(ci.Filepath + META_EXT)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	for encoding := range ci.EncodedSizes {
+		os.Remove(encodedPath(ci.Filepath, encoding))
+	}
+	// 이미 없는 본문은 지운 것으로 봄
+	err = os.Remove(ci.Filepath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// Dirs의 메타데이터 파일을 읽어서 캐시 목록을 다시 만듦. 만료되었거나 깨진 캐시는 파일까지 지움
+func (fc *FileCache) loadIndex() {
+	isRemovable := fc.IsRemovable
+	if isRemovable == nil {
+		isRemovable = func(ci CacheItem) bool { return ci.ExpirationTime.Before(time.Now()) }
+	}
+
+	for _, dir := range fc.Dirs {
+		files, err := os.ReadDir(dir)
+		if err != nil {
+			continue // 아직 캐시가 저장된 적 없음
+		}
+
+		indexed := map[string]bool{}
+		for _, file := range files {
+			if file.IsDir() || !strings.HasSuffix(file.Name(), META_EXT) {
+				continue
+			}
+			metaPath := filepath.Join(dir, file.Name())
+			entry, err := readEntry(metaPath)
+			if err != nil {
+				fmt.Printf("Discard cache (%v) : %s\n", err, metaPath)
+				os.Remove(metaPath)
+				continue
+			}
+			if isRemovable(entry.Ci) {
+				removeEntry(entry.Ci)
+				continue
+			}
+			fc.SciList[entry.HashKey].CiMap[entry.Sha256] = entry.Ci
+			indexed[filepath.Base(entry.Ci.Filepath)] = true
+			for encoding := range entry.Ci.EncodedSizes {
+				indexed[filepath.Base(encodedPath(entry.Ci.Filepath, encoding))] = true
+			}
+		}
+
+		// 메타데이터가 없는 본문 파일, 남아 있는 임시 파일
+		for _, file := range files {
+			name := file.Name()
+			if !file.IsDir() && !strings.HasSuffix(name, META_EXT) && !indexed[name] {
+				os.Remove(filepath.Join(dir, name))
+			}
+		}
+	}
+}
+
+func readEntry(metaPath string) (entry fileEntry, err error) {
+	metaJSON, err := os.ReadFile(metaPath)
+	if err != nil {
+		return entry, err
+	}
+	err = json.Unmarshal(metaJSON, &entry)
+	if err != nil {
+		return entry, err
+	}
+	if entry.HashKey < 0 || entry.HashKey >= 255 || entry.Sha256 == "" {
+		return entry, fmt.Errorf("invalid key")
+	}
+	if filepath.Clean(entry.Ci.Filepath+META_EXT) != filepath.Clean(metaPath) {
+		return entry, fmt.Errorf("moved entry")
+	}
+	info, err := os.Stat(entry.Ci.Filepath)
+	if err != nil {
+		return entry, err
+	}
+	if info.Size() != entry.Ci.Size {
+		return entry, fmt.Errorf("body size mismatch")
+	}
+	// 압축된 본문이 깨졌으면 그 encoding만 버림
+	for encoding, size := range entry.Ci.EncodedSizes {
+		info, err := os.Stat(encodedPath(entry.Ci.Filepath, encoding))
+		if err != nil || info.Size() != size {
+			delete(entry.Ci.EncodedSizes, encoding)
+		}
+	}
+	return entry, nil
+}
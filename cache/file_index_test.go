@@ -0,0 +1,62 @@
+package cache_test
+
+import (
+	"jnlee/cache"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileCacheIndex(t *testing.T) {
+	dir := t.TempDir()
+	newFileCache := func() *cache.FileCache {
+		fc := &cache.FileCache{Dirs: []string{dir}}
+		fc.Init()
+		return fc
+	}
+	ci := func(name string, expiration time.Time) cache.CacheItem {
+		return cache.CacheItem{
+			Header:         http.Header{"Etag": {`"` + name + `"`}},
+			Body:           []byte("body of " + name),
+			URL:            "/" + name,
+			Host:           "host",
+			Filepath:       filepath.Join(dir, name),
+			ExpirationTime: expiration,
+		}
+	}
+
+	fc := newFileCache()
+	fc.Set(1, "fresh", ci("fresh", time.Now().Add(time.Hour)))
+	fc.Set(2, "expired", ci("expired", time.Now().Add(-time.Hour)))
+	fc.Set(3, "corrupt", ci("corrupt", time.Now().Add(time.Hour)))
+	os.WriteFile(filepath.Join(dir, "corrupt"), []byte("short"), 0644)
+	os.WriteFile(filepath.Join(dir, "orphan"), []byte("no meta"), 0644)
+
+	// 재시작
+	fc = newFileCache()
+	got, exist := fc.Get(1, "fresh")
+	if !exist || string(got.Body) != "body of fresh" || got.URL != "/fresh" || got.Header.Get("Etag") != `"fresh"` {
+		t.Errorf("Get(fresh) = %+v, %v", got, exist)
+	}
+	if _, exist := fc.Get(2, "expired"); exist {
+		t.Error("expired entry must be discarded")
+	}
+	if _, exist := fc.Get(3, "corrupt"); exist {
+		t.Error("corrupt entry must be discarded")
+	}
+	if all := fc.GetAll(); len(all) != 1 || all[0].Ci.Size != int64(len("body of fresh")) {
+		t.Errorf("GetAll() = %+v", all)
+	}
+
+	files, _ := os.ReadDir(dir)
+	if len(files) != 2 {
+		t.Errorf("files left %v, want fresh and fresh.meta", files)
+	}
+
+	fc.Del(1, "fresh")
+	if fc = newFileCache(); len(fc.GetAll()) != 0 {
+		t.Error("deleted entry must not be loaded")
+	}
+}
@@ -0,0 +1,286 @@
+package cache
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// 본문을 나눠서 받아 저장. 다 받으면 Commit, 저장하지 않을 거면 Abort
+type CacheWriter interface {
+	io.Writer
+	// 같은 본문을 encoding으로 압축한 내용을 쓰는 Writer. Commit할 때 함께 저장됨
+	Encoded(encoding string) io.Writer
+	Commit() error
+	Abort()
+}
+
+// 본문을 모두 모은 뒤 set으로 저장. 본문을 메모리나 Redis에 두는 Cache에서 사용
+type bufferWriter struct {
+	ci      CacheItem
+	buf     bytes.Buffer
+	encoded map[string]*bytes.Buffer
+	set     func(ci CacheItem)
+}
+
+func (bw *bufferWriter) Write(p []byte) (int, error) {
+	return bw.buf.Write(p)
+}
+
+func (bw *bufferWriter) Encoded(encoding string) io.Writer {
+	if bw.encoded == nil {
+		bw.encoded = map[string]*bytes.Buffer{}
+	}
+	if bw.encoded[encoding] == nil {
+		bw.encoded[encoding] = &bytes.Buffer{}
+	}
+	return bw.encoded[encoding]
+}
+
+func (bw *bufferWriter) Commit() error {
+	ci := bw.ci
+	ci.Body = bw.buf.Bytes()
+	ci.EncodedBodies = map[string][]byte{}
+	for encoding, buf := range bw.encoded {
+		ci.EncodedBodies[encoding] = buf.Bytes()
+	}
+	bw.set(ci)
+	return nil
+}
+
+// 모아둔 본문을 버림
+func (bw *bufferWriter) Abort() {
+	bw.buf = bytes.Buffer{}
+	bw.encoded = nil
+}
+
+// 본문을 메모리에 가지고 있는 CacheItem을 Open의 결과로 바꿈
+func openBytes(ci CacheItem, exist bool) (CacheItem, io.ReadCloser, bool) {
+	if !exist {
+		return ci, nil, false
+	}
+	ci.setSizes()
+	body := io.NopCloser(bytes.NewReader(ci.Body))
+	ci.Body = nil
+	return ci, body, true
+}
+
+func openEncodedBytes(ci CacheItem, exist bool, encoding string) (io.ReadCloser, bool) {
+	body, ok := ci.EncodedBodies[encoding]
+	if !exist || !ok {
+		return nil, false
+	}
+	return io.NopCloser(bytes.NewReader(body)), true
+}
+
+//
+//
+// File
+
+// 임시 파일에 쓰고 Commit할 때 본문 파일로 이름을 바꿈
+type fileWriter struct {
+	fc      *FileCache
+	hashKey int
+	sha256  string
+	ci      CacheItem
+	file    *tempFile
+	encoded map[string]*tempFile
+	err     error // 압축된 본문의 임시 파일을 만들지 못함
+}
+
+type tempFile struct {
+	*os.File
+	size int64
+}
+
+func (tf *tempFile) Write(p []byte) (int, error) {
+	n, err := tf.File.Write(p)
+	tf.size += int64(n)
+	return n, err
+}
+
+// 같은 key를 동시에 저장하는 경우가 있으므로 임시 파일 이름은 겹치지 않게 만듦
+func createTempFile(path string) (*tempFile, error) {
+	file, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return nil, err
+	}
+	return &tempFile{File: file}, nil
+}
+
+func (fc *FileCache) Open(hashKey int, sha256 string) (ci CacheItem, body io.ReadCloser, exist bool) {
+	sci := fc.SciList[hashKey]
+	sci.RW.RLock()
+	ci, exist = sci.CiMap[sha256]
+	if !exist {
+		sci.RW.RUnlock()
+		return ci, nil, false
+	}
+	// 파일을 연 뒤에 캐시가 지워져도 열린 파일은 끝까지 읽을 수 있음
+	file, err := os.Open(ci.Filepath)
+	sci.RW.RUnlock()
+
+	if err != nil {
+		fc.dropUnreadable(hashKey, sha256, ci, err)
+		return CacheItem{}, nil, false
+	}
+	return ci, file, true
+}
+
+func (fc *FileCache) OpenEncoded(hashKey int, sha256 string, encoding string) (body io.ReadCloser, exist bool) {
+	sci := fc.SciList[hashKey]
+	sci.RW.RLock()
+	defer sci.RW.RUnlock()
+
+	ci, exist := sci.CiMap[sha256]
+	if _, ok := ci.EncodedSizes[encoding]; !exist || !ok {
+		return nil, false
+	}
+	file, err := os.Open(encodedPath(ci.Filepath, encoding))
+	if err != nil {
+		return nil, false
+	}
+	return file, true
+}
+
+func (fc *FileCache) NewWriter(hashKey int, sha256 string, ci CacheItem) (CacheWriter, error) {
+	err := os.MkdirAll(filepath.Dir(ci.Filepath), os.ModePerm)
+	if err != nil {
+		return nil, err
+	}
+	file, err := createTempFile(ci.Filepath)
+	if err != nil {
+		return nil, err
+	}
+	return &fileWriter{fc: fc, hashKey: hashKey, sha256: sha256, ci: ci, file: file, encoded: map[string]*tempFile{}}, nil
+}
+
+func (fw *fileWriter) Write(p []byte) (int, error) {
+	return fw.file.Write(p)
+}
+
+func (fw *fileWriter) Encoded(encoding string) io.Writer {
+	if tf, exist := fw.encoded[encoding]; exist {
+		return tf
+	}
+	tf, err := createTempFile(encodedPath(fw.ci.Filepath, encoding))
+	if err != nil {
+		fw.err = err
+		return io.Discard
+	}
+	fw.encoded[encoding] = tf
+	return tf
+}
+
+func (fw *fileWriter) Commit() error {
+	err := fw.err
+	for _, tf := range fw.tempFiles() {
+		if closeErr := tf.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		fw.removeTempFiles()
+		return err
+	}
+
+	sci := fw.fc.SciList[fw.hashKey]
+	sci.RW.Lock()
+	defer sci.RW.Unlock()
+
+	ci := fw.ci
+	ci.Body, ci.EncodedBodies = nil, nil
+	ci.Size = fw.file.size
+	ci.EncodedSizes = map[string]int64{}
+	for encoding, tf := range fw.encoded {
+		ci.EncodedSizes[encoding] = tf.size
+		err = os.Rename(tf.Name(), encodedPath(ci.Filepath, encoding))
+		if err != nil {
+			fw.removeTempFiles()
+			return err
+		}
+	}
+	err = os.Rename(fw.file.Name(), ci.Filepath)
+	if err != nil {
+		fw.removeTempFiles()
+		return err
+	}
+	err = writeMeta(fw.hashKey, fw.sha256, ci)
+	if err != nil {
+		// 본문은 이미 바뀌었으므로 이전 메타데이터와 함께 지움
+		if old, exist := sci.CiMap[fw.sha256]; exist {
+			removeEntry(old)
+			delete(sci.CiMap, fw.sha256)
+		}
+		for encoding := range ci.EncodedSizes {
+			os.Remove(encodedPath(ci.Filepath, encoding))
+		}
+		os.Remove(ci.Filepath)
+		return err
+	}
+	// 이전 캐시에만 있던 압축된 본문
+	for encoding := range sci.CiMap[fw.sha256].EncodedSizes {
+		if _, exist := ci.EncodedSizes[encoding]; !exist {
+			os.Remove(encodedPath(ci.Filepath, encoding))
+		}
+	}
+	sci.CiMap[fw.sha256] = ci
+	return nil
+}
+
+func (fw *fileWriter) Abort() {
+	for _, tf := range fw.tempFiles() {
+		tf.Close()
+	}
+	fw.removeTempFiles()
+}
+
+func (fw *fileWriter) tempFiles() []*tempFile {
+	tempFiles := []*tempFile{fw.file}
+	for _, tf := range fw.encoded {
+		tempFiles = append(tempFiles, tf)
+	}
+	return tempFiles
+}
+
+// 이름을 바꾼 뒤에는 임시 파일이 없으므로 에러는 무시
+func (fw *fileWriter) removeTempFiles() {
+	for _, tf := range fw.tempFiles() {
+		os.Remove(tf.Name())
+	}
+}
+
+//
+//
+// Redis
+
+func (rc *RedisCache) Open(hashKey int, sha256 string) (ci CacheItem, body io.ReadCloser, exist bool) {
+	return openBytes(rc.Get(hashKey, sha256))
+}
+
+func (rc *RedisCache) OpenEncoded(hashKey int, sha256 string, encoding string) (body io.ReadCloser, exist bool) {
+	ci, exist := rc.Get(hashKey, sha256)
+	return openEncodedBytes(ci, exist, encoding)
+}
+
+func (rc *RedisCache) NewWriter(hashKey int, sha256 string, ci CacheItem) (CacheWriter, error) {
+	return &bufferWriter{ci: ci, set: func(ci CacheItem) { rc.Set(hashKey, sha256, ci) }}, nil
+}
+
+//
+//
+// Memory
+
+func (mc *MemoryCache) Open(hashKey int, sha256 string) (ci CacheItem, body io.ReadCloser, exist bool) {
+	return openBytes(mc.Get(hashKey, sha256))
+}
+
+func (mc *MemoryCache) OpenEncoded(hashKey int, sha256 string, encoding string) (body io.ReadCloser, exist bool) {
+	ci, exist := mc.Get(hashKey, sha256)
+	return openEncodedBytes(ci, exist, encoding)
+}
+
+func (mc *MemoryCache) NewWriter(hashKey int, sha256 string, ci CacheItem) (CacheWriter, error) {
+	return &bufferWriter{ci: ci, set: func(ci CacheItem) { mc.Set(hashKey, sha256, ci) }}, nil
+}
@@ -0,0 +1,192 @@
+package cache_test
+
+import (
+	"io"
+	"jnlee/cache"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileCacheWriter(t *testing.T) {
+	dir := t.TempDir()
+	fc := &cache.FileCache{Dirs: []string{dir}}
+	fc.Init()
+	ci := cache.CacheItem{URL: "/stream", Filepath: filepath.Join(dir, "stream"), ExpirationTime: time.Now().Add(time.Hour)}
+
+	cw, err := fc.NewWriter(1, "stream", ci)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cw.Write([]byte("first "))
+	if _, _, exist := fc.Open(1, "stream"); exist {
+		t.Error("entry must not exist before Commit")
+	}
+	cw.Write([]byte("second"))
+	cw.Encoded("gzip").Write([]byte("compressed"))
+	if err := cw.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	got, body, exist := fc.Open(1, "stream")
+	if !exist {
+		t.Fatal("entry must exist after Commit")
+	}
+	content, _ := io.ReadAll(body)
+	body.Close()
+	if string(content) != "first second" || got.Size != 12 || got.Body != nil {
+		t.Errorf("Open() = %+v, %q", got, content)
+	}
+
+	body, exist = fc.OpenEncoded(1, "stream", "gzip")
+	if !exist || got.EncodedSizes["gzip"] != 10 {
+		t.Fatalf("OpenEncoded() = %v, sizes %v", exist, got.EncodedSizes)
+	}
+	content, _ = io.ReadAll(body)
+	body.Close()
+	if string(content) != "compressed" {
+		t.Errorf("OpenEncoded() = %q", content)
+	}
+	if _, exist := fc.OpenEncoded(1, "stream", "br"); exist {
+		t.Error("br is not stored")
+	}
+
+	// 재시작해도 압축된 본문을 찾을 수 있음
+	fc = &cache.FileCache{Dirs: []string{dir}}
+	fc.Init()
+	if body, exist := fc.OpenEncoded(1, "stream", "gzip"); !exist {
+		t.Error("encoded body must be loaded from index")
+	} else {
+		body.Close()
+	}
+
+	cw, _ = fc.NewWriter(2, "aborted", cache.CacheItem{Filepath: filepath.Join(dir, "aborted")})
+	cw.Write([]byte("partial"))
+	cw.Abort()
+	if _, _, exist := fc.Open(2, "aborted"); exist {
+		t.Error("aborted entry must not exist")
+	}
+	files, _ := os.ReadDir(dir)
+	if len(files) != 3 {
+		t.Errorf("files left %v, want stream, stream.gzip and stream.meta", files)
+	}
+}
+
+func TestBoundedCacheWriter(t *testing.T) {
+	policy, _ := cache.NewEvictionPolicy(cache.POLICY_LRU)
+	bc := &cache.BoundedCache{Backend: &cache.MemoryCache{}, Policy: policy, MaxBytes: 15}
+	bc.Init()
+
+	for _, key := range []string{"a", "b"} {
+		cw, _ := bc.NewWriter(0, key, cache.CacheItem{URL: key})
+		cw.Write([]byte("0123456789"))
+		cw.Commit()
+	}
+
+	if _, _, exist := bc.Open(0, "a"); exist {
+		t.Error("a must be evicted")
+	}
+	got, body, exist := bc.Open(0, "b")
+	if !exist || got.Size != 10 {
+		t.Errorf("Open(b) = %+v, %v", got, exist)
+	}
+	body.Close()
+	if stats := bc.Stats(); stats.UsedBytes != 10 || stats.Evictions != 1 {
+		t.Errorf("stats %+v", stats)
+	}
+}
+
+func TestUpdateMeta(t *testing.T) {
+	dir := t.TempDir()
+	policy, _ := cache.NewEvictionPolicy(cache.POLICY_LRU)
+	caches := map[string]cache.Cache{
+		"file":   &cache.FileCache{Dirs: []string{dir}},
+		"memory": &cache.MemoryCache{},
+		"tiered": &cache.TieredCache{Memory: &cache.BoundedCache{Backend: &cache.MemoryCache{}, Policy: policy}, Backend: &cache.FileCache{}},
+	}
+	for name, c := range caches {
+		c.Init()
+		ci := cache.CacheItem{URL: name, Filepath: filepath.Join(dir, name)}
+		cw, _ := c.NewWriter(1, name, ci)
+		cw.Write([]byte("body"))
+		cw.Encoded("gzip").Write([]byte("gzipped"))
+		cw.Commit()
+
+		expiration := time.Now().Add(time.Hour)
+		if !c.UpdateMeta(1, name, func(ci *cache.CacheItem) { ci.ExpirationTime = expiration }) {
+			t.Fatalf("%s : UpdateMeta() = false", name)
+		}
+		if c.UpdateMeta(1, "missing", func(ci *cache.CacheItem) {}) {
+			t.Errorf("%s : missing entry updated", name)
+		}
+
+		// 본문과 압축된 본문은 그대로 남음
+		got, body, _ := c.Open(1, name)
+		content, _ := io.ReadAll(body)
+		body.Close()
+		if !got.ExpirationTime.Equal(expiration) || string(content) != "body" || got.Size != 4 || got.EncodedSizes["gzip"] != 7 {
+			t.Errorf("%s : Open() = %+v, %q", name, got, content)
+		}
+		encoded, exist := c.OpenEncoded(1, name, "gzip")
+		if !exist {
+			t.Fatalf("%s : encoded body lost", name)
+		}
+		content, _ = io.ReadAll(encoded)
+		encoded.Close()
+		if string(content) != "gzipped" {
+			t.Errorf("%s : OpenEncoded() = %q", name, content)
+		}
+	}
+}
+
+func TestFileCacheMissingFile(t *testing.T) {
+	dir := t.TempDir()
+	fc := &cache.FileCache{Dirs: []string{dir}}
+	fc.Init()
+	path := filepath.Join(dir, "missing")
+
+	// 메타데이터를 쓰지 못하면 본문도 남기지 않음
+	os.MkdirAll(filepath.Join(path+".meta", "dir"), os.ModePerm)
+	cw, _ := fc.NewWriter(1, "missing", cache.CacheItem{Filepath: path})
+	cw.Write([]byte("body"))
+	cw.Encoded("gzip").Write([]byte("gzipped"))
+	if err := cw.Commit(); err == nil {
+		t.Fatal("Commit() must fail when meta is not written")
+	}
+	for _, name := range []string{path, path + ".gzip"} {
+		if _, err := os.Stat(name); !os.IsNotExist(err) {
+			t.Errorf("%s left after failed Commit", name)
+		}
+	}
+	if _, _, exist := fc.Open(1, "missing"); exist {
+		t.Error("failed entry must not exist")
+	}
+
+	// 본문 파일이 밖에서 지워지면 없는 캐시로 봄
+	os.RemoveAll(path + ".meta")
+	cw, _ = fc.NewWriter(1, "missing", cache.CacheItem{Filepath: path})
+	cw.Write([]byte("body"))
+	if err := cw.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	os.Remove(path)
+	if _, body, exist := fc.Open(1, "missing"); exist || body != nil {
+		t.Error("Open() must not find a removed file")
+	}
+	if fc.Exists(1, "missing") {
+		t.Error("removed file must be dropped from the index")
+	}
+	if _, err := os.Stat(path + ".meta"); !os.IsNotExist(err) {
+		t.Error("meta of removed file must be deleted")
+	}
+
+	fc.Set(1, "missing", cache.CacheItem{Body: []byte("body"), Filepath: path})
+	os.Remove(path)
+	fc.Del(1, "missing") // 본문이 없어도 panic하지 않음
+	fc.Set(1, "missing", cache.CacheItem{Body: []byte("body"), Filepath: path})
+	os.Remove(path)
+	if _, exist := fc.Get(1, "missing"); exist || fc.Exists(1, "missing") {
+		t.Error("Get() must not find a removed file")
+	}
+}
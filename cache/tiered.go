@@ -0,0 +1,341 @@
+package cache
+
+import (
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+// 본문까지 메모리에 두는 Cache. TieredCache의 메모리 계층으로 사용
+type MemoryCache struct {
+	SciList []*SafeCacheItem
+}
+
+func (mc *MemoryCache) Init() {
+	mc.SciList = nil
+	for i := 0; i < 255; i++ {
+		sci := &SafeCacheItem{
+			RW:    &sync.RWMutex{},
+			CiMap: make(map[string]CacheItem),
+		}
+		mc.SciList = append(mc.SciList, sci)
+	}
+}
+
+func (mc *MemoryCache) Close() {}
+
+func (mc *MemoryCache) Clear() { //For Test
+	for _, sci := range mc.SciList {
+		sci.RW.Lock()
+		sci.CiMap = make(map[string]CacheItem)
+		sci.RW.Unlock()
+	}
+}
+
+func (mc *MemoryCache) Get(hashKey int, sha256 string) (ci CacheItem, exist bool) {
+	sci := mc.SciList[hashKey]
+	sci.RW.RLock()
+	defer sci.RW.RUnlock()
+
+	ci, exist = sci.CiMap[sha256]
+	return ci, exist
+}
+
+func (mc *MemoryCache) Exists(hashKey int, sha256 string) bool {
+	_, exist := mc.Get(hashKey, sha256)
+	return exist
+}
+
+func (mc *MemoryCache) GetAll() (cacheDataList []CacheData) {
+	for hashKey, sci := range mc.SciList {
+		sci.RW.RLock()
+		for sha256, ci := range sci.CiMap {
+			cacheDataList = append(cacheDataList, CacheData{hashKey, sha256, ci})
+		}
+		sci.RW.RUnlock()
+	}
+	return cacheDataList
+}
+
+func (mc *MemoryCache) Set(hashKey int, sha256 string, ci CacheItem) {
+	sci := mc.SciList[hashKey]
+	sci.RW.Lock()
+	defer sci.RW.Unlock()
+	ci.setSizes()
+	sci.CiMap[sha256] = ci
+}
+
+func (mc *MemoryCache) UpdateMeta(hashKey int, sha256 string, update func(ci *CacheItem)) bool {
+	sci := mc.SciList[hashKey]
+	sci.RW.Lock()
+	defer sci.RW.Unlock()
+
+	ci, exist := sci.CiMap[sha256]
+	if !exist {
+		return false
+	}
+	update(&ci)
+	sci.CiMap[sha256] = ci
+	return true
+}
+
+func (mc *MemoryCache) Del(hashKey int, sha256 string) {
+	sci := mc.SciList[hashKey]
+	sci.RW.Lock()
+	defer sci.RW.Unlock()
+	delete(sci.CiMap, sha256)
+}
+
+//
+//
+// Tiered
+
+// 크기가 제한된 메모리 계층(Memory)을 File/Redis(Backend) 앞에 둠.
+// 저장은 두 계층에 모두 하고(write-through), Backend에서만 찾은 캐시는 메모리로 올림.
+// 메모리에서 밀려난 캐시는 Backend에만 남음
+type TieredCache struct {
+	Memory  Cache // 보통 MemoryCache를 감싼 BoundedCache
+	Backend Cache
+
+	locks       [255]sync.Mutex // Backend에서 읽어 올리는 중에 Set/Del이 끼어들지 않도록 hashKey별로 잠금
+	memoryHits  atomic.Int64
+	backendHits atomic.Int64
+	misses      atomic.Int64
+}
+
+type TierStats struct {
+	MemoryHits  int64
+	BackendHits int64
+	Misses      int64
+}
+
+func (tc *TieredCache) Init() {
+	tc.Memory.Init()
+	tc.Backend.Init()
+}
+
+func (tc *TieredCache) Close() {
+	tc.Memory.Close()
+	tc.Backend.Close()
+}
+
+func (tc *TieredCache) Clear() { //For Test
+	tc.Memory.Clear()
+	tc.Backend.Clear()
+}
+
+func (tc *TieredCache) Get(hashKey int, sha256 string) (ci CacheItem, exist bool) {
+	if ci, exist = tc.Memory.Get(hashKey, sha256); exist {
+		tc.memoryHits.Add(1)
+		return ci, exist
+	}
+
+	tc.locks[hashKey].Lock()
+	defer tc.locks[hashKey].Unlock()
+
+	ci, exist = tc.Backend.Get(hashKey, sha256)
+	if !exist {
+		tc.misses.Add(1)
+		return ci, exist
+	}
+	tc.backendHits.Add(1)
+	if tc.fitsMemory(ci.storedSize()) {
+		tc.Memory.Set(hashKey, sha256, tc.withEncodedBodies(hashKey, sha256, ci))
+	}
+	return ci, exist
+}
+
+// 메모리 계층의 MaxBytes보다 큰 캐시는 올려도 바로 밀려나므로 올리지 않음
+func (tc *TieredCache) fitsMemory(size int64) bool {
+	limit := tc.memoryLimit()
+	return limit == 0 || size <= limit
+}
+
+// 0이면 제한 없음
+func (tc *TieredCache) memoryLimit() int64 {
+	if bc, ok := tc.Memory.(*BoundedCache); ok {
+		return bc.maxBytes()
+	}
+	return 0
+}
+
+// FileCache처럼 압축된 본문을 따로 두는 Backend에서 메모리로 올릴 때 함께 읽음
+func (tc *TieredCache) withEncodedBodies(hashKey int, sha256 string, ci CacheItem) CacheItem {
+	encodedBodies := map[string][]byte{}
+	for encoding := range ci.EncodedSizes {
+		if body, exist := ci.EncodedBodies[encoding]; exist {
+			encodedBodies[encoding] = body
+			continue
+		}
+		body, exist := tc.Backend.OpenEncoded(hashKey, sha256, encoding)
+		if !exist {
+			continue
+		}
+		encodedBodies[encoding], _ = io.ReadAll(body)
+		body.Close()
+	}
+	ci.EncodedBodies = encodedBodies
+	return ci
+}
+
+func (tc *TieredCache) Open(hashKey int, sha256 string) (ci CacheItem, body io.ReadCloser, exist bool) {
+	if ci, body, exist = tc.Memory.Open(hashKey, sha256); exist {
+		tc.memoryHits.Add(1)
+		return ci, body, exist
+	}
+
+	tc.locks[hashKey].Lock()
+	defer tc.locks[hashKey].Unlock()
+
+	ci, body, exist = tc.Backend.Open(hashKey, sha256)
+	if !exist {
+		tc.misses.Add(1)
+		return ci, body, exist
+	}
+	tc.backendHits.Add(1)
+	// 메모리로 올리지 않으면 Backend에서 바로 읽음
+	if !tc.fitsMemory(ci.storedSize()) {
+		return ci, body, exist
+	}
+
+	// 메모리로 올려야 하므로 본문을 모두 읽음
+	ci.Body, _ = io.ReadAll(body)
+	body.Close()
+	ci = tc.withEncodedBodies(hashKey, sha256, ci)
+	tc.Memory.Set(hashKey, sha256, ci)
+	return openBytes(ci, exist)
+}
+
+func (tc *TieredCache) OpenEncoded(hashKey int, sha256 string, encoding string) (body io.ReadCloser, exist bool) {
+	if body, exist = tc.Memory.OpenEncoded(hashKey, sha256, encoding); exist {
+		return body, exist
+	}
+	return tc.Backend.OpenEncoded(hashKey, sha256, encoding)
+}
+
+// 메모리 계층은 Backend의 일부이므로 Backend만 확인. 메모리로 올리지 않음
+func (tc *TieredCache) Exists(hashKey int, sha256 string) bool {
+	return tc.Backend.Exists(hashKey, sha256)
+}
+
+// 메모리 계층은 Backend의 일부이므로 Backend만 확인
+func (tc *TieredCache) GetAll() (ciList []CacheData) {
+	return tc.Backend.GetAll()
+}
+
+func (tc *TieredCache) Set(hashKey int, sha256 string, ci CacheItem) {
+	tc.locks[hashKey].Lock()
+	defer tc.locks[hashKey].Unlock()
+
+	tc.Backend.Set(hashKey, sha256, ci)
+	tc.Memory.Set(hashKey, sha256, ci)
+}
+
+// 메모리에 없는 캐시는 올리지 않고 Backend에서만 바꿈
+func (tc *TieredCache) UpdateMeta(hashKey int, sha256 string, update func(ci *CacheItem)) bool {
+	tc.locks[hashKey].Lock()
+	defer tc.locks[hashKey].Unlock()
+
+	tc.Memory.UpdateMeta(hashKey, sha256, update)
+	return tc.Backend.UpdateMeta(hashKey, sha256, update)
+}
+
+func (tc *TieredCache) NewWriter(hashKey int, sha256 string, ci CacheItem) (CacheWriter, error) {
+	cw, err := tc.Backend.NewWriter(hashKey, sha256, ci)
+	if err != nil {
+		return nil, err
+	}
+	tw := &tieredWriter{tc: tc, backend: cw, hashKey: hashKey, sha256: sha256, limit: tc.memoryLimit()}
+	tw.buf = bufferWriter{ci: ci, set: func(ci CacheItem) { tc.Memory.Set(hashKey, sha256, ci) }}
+	return tw, nil
+}
+
+// Backend에 저장하면서 메모리 계층에 넣을 본문도 모아둠.
+// 모은 크기가 메모리 계층의 MaxBytes를 넘으면 그만 모으고 Backend에만 저장
+type tieredWriter struct {
+	tc       *TieredCache
+	backend  CacheWriter
+	buf      bufferWriter
+	hashKey  int
+	sha256   string
+	limit    int64 // 0이면 제한 없음
+	size     int64 // 지금까지 쓴 본문과 압축된 본문의 크기
+	overflow bool  // limit을 넘어서 메모리 계층에 넣지 않음
+}
+
+func (tw *tieredWriter) Write(p []byte) (int, error) {
+	n, err := tw.backend.Write(p)
+	if tw.grow(n) {
+		tw.buf.Write(p[:n])
+	}
+	return n, err
+}
+
+func (tw *tieredWriter) Encoded(encoding string) io.Writer {
+	if !tw.overflow {
+		tw.buf.Encoded(encoding)
+	}
+	return &tieredEncodedWriter{tw, tw.backend.Encoded(encoding), encoding}
+}
+
+// 쓴 크기를 더하고, 계속 모아도 되면 true. limit을 넘으면 모은 본문을 버림
+func (tw *tieredWriter) grow(n int) bool {
+	if tw.overflow {
+		return false
+	}
+	tw.size += int64(n)
+	if tw.limit > 0 && tw.size > tw.limit {
+		tw.overflow = true
+		tw.buf.Abort()
+		return false
+	}
+	return true
+}
+
+type tieredEncodedWriter struct {
+	tw       *tieredWriter
+	backend  io.Writer
+	encoding string
+}
+
+func (ew *tieredEncodedWriter) Write(p []byte) (int, error) {
+	n, err := ew.backend.Write(p)
+	if ew.tw.grow(n) {
+		ew.tw.buf.Encoded(ew.encoding).Write(p[:n])
+	}
+	return n, err
+}
+
+func (tw *tieredWriter) Commit() error {
+	tc := tw.tc
+	tc.locks[tw.hashKey].Lock()
+	defer tc.locks[tw.hashKey].Unlock()
+
+	err := tw.backend.Commit()
+	if err != nil {
+		return err
+	}
+	// 메모리에 남은 이전 캐시는 Backend와 달라졌으므로 지움
+	if tw.overflow {
+		tc.Memory.Del(tw.hashKey, tw.sha256)
+		return nil
+	}
+	return tw.buf.Commit()
+}
+
+func (tw *tieredWriter) Abort() {
+	tw.backend.Abort()
+	tw.buf.Abort()
+}
+
+func (tc *TieredCache) Del(hashKey int, sha256 string) {
+	tc.locks[hashKey].Lock()
+	defer tc.locks[hashKey].Unlock()
+
+	tc.Memory.Del(hashKey, sha256)
+	tc.Backend.Del(hashKey, sha256)
+}
+
+func (tc *TieredCache) Stats() TierStats {
+	return TierStats{tc.memoryHits.Load(), tc.backendHits.Load(), tc.misses.Load()}
+}
@@ -0,0 +1,104 @@
+package cache_test
+
+import (
+	"io"
+	"jnlee/cache"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTieredCache(t *testing.T) {
+	dir := t.TempDir()
+	policy, _ := cache.NewEvictionPolicy(cache.POLICY_LRU)
+	memory := &cache.BoundedCache{Backend: &cache.MemoryCache{}, Policy: policy, MaxItems: 1}
+	backend := &cache.FileCache{}
+	tc := &cache.TieredCache{Memory: memory, Backend: backend}
+	tc.Init()
+
+	ci := func(name string) cache.CacheItem {
+		return cache.CacheItem{Body: []byte(name), URL: name, Filepath: filepath.Join(dir, name)}
+	}
+	tc.Set(1, "a", ci("a"))
+	tc.Set(2, "b", ci("b")) // 메모리에서 a가 밀려남
+
+	if _, exist := memory.Get(1, "a"); exist {
+		t.Error("a must be demoted from memory")
+	}
+	if got, exist := tc.Get(1, "a"); !exist || string(got.Body) != "a" {
+		t.Errorf("Get(a) = %q, %v", got.Body, exist)
+	}
+	if _, exist := memory.Get(1, "a"); !exist {
+		t.Error("a must be promoted to memory")
+	}
+
+	// 메모리에 있으면 파일을 읽지 않음
+	os.Remove(filepath.Join(dir, "a"))
+	if got, exist := tc.Get(1, "a"); !exist || string(got.Body) != "a" {
+		t.Errorf("Get(a) from memory = %q, %v", got.Body, exist)
+	}
+	tc.Get(3, "c")
+
+	want := cache.TierStats{MemoryHits: 1, BackendHits: 1, Misses: 1}
+	if stats := tc.Stats(); stats != want {
+		t.Errorf("stats %+v, want %+v", stats, want)
+	}
+
+	// Exists는 메모리로 올리지 않음
+	if !tc.Exists(2, "b") || tc.Exists(3, "c") {
+		t.Error("Exists() mismatch")
+	}
+	if _, exist := memory.Get(2, "b"); exist {
+		t.Error("b must not be promoted by Exists")
+	}
+
+	tc.Del(2, "b")
+	if _, exist := tc.Get(2, "b"); exist {
+		t.Error("b must be deleted from both tiers")
+	}
+	if len(tc.GetAll()) != 1 {
+		t.Errorf("GetAll() = %v", tc.GetAll())
+	}
+}
+
+func TestTieredCacheLargeItem(t *testing.T) {
+	dir := t.TempDir()
+	policy, _ := cache.NewEvictionPolicy(cache.POLICY_LRU)
+	memory := &cache.BoundedCache{Backend: &cache.MemoryCache{}, Policy: policy, MaxBytes: 5}
+	tc := &cache.TieredCache{Memory: memory, Backend: &cache.FileCache{}}
+	tc.Init()
+
+	ci := cache.CacheItem{URL: "a", Filepath: filepath.Join(dir, "a")}
+	tc.Set(1, "a", cache.CacheItem{Body: []byte("old"), URL: "a", Filepath: ci.Filepath})
+
+	// MaxBytes를 넘으면 메모리에 모으지 않고, 메모리에 있던 이전 캐시도 지움
+	cw, err := tc.NewWriter(1, "a", ci)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(cw, "large")
+	io.WriteString(cw.Encoded("gzip"), "gz")
+	if err := cw.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if _, exist := memory.Get(1, "a"); exist {
+		t.Error("large item must not be kept in memory")
+	}
+
+	// 메모리로 올리지 않고 Backend에서 바로 읽음
+	got, body, exist := tc.Open(1, "a")
+	if !exist {
+		t.Fatal("Open(a) miss")
+	}
+	data, _ := io.ReadAll(body)
+	body.Close()
+	if string(data) != "large" || got.Size != 5 {
+		t.Errorf("Open(a) = %q, size %d", data, got.Size)
+	}
+	if _, exist := memory.Get(1, "a"); exist {
+		t.Error("large item must not be promoted to memory")
+	}
+	if stats := memory.Stats(); stats.Items != 0 || stats.Evictions != 0 {
+		t.Errorf("memory stats %+v", stats)
+	}
+}
@@ -1,5 +1,9 @@
 module jnlee
 
-go 1.21.3
+go 1.22
 
-require github.com/go-redis/redis v6.15.9+incompatible // indirect
+require (
+	github.com/andybalholm/brotli v1.1.1
+	github.com/go-redis/redis v6.15.9+incompatible
+	github.com/klauspost/compress v1.18.0
+)
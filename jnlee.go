@@ -1,7 +1,13 @@
 package main
 
-import "jnlee/wcs"
+import (
+	"flag"
+	"jnlee/wcs"
+)
 
 func main() {
+	flag.BoolVar(&wcs.ClearCacheOnStart, "clear-cache", false, "remove all stored caches on start")
+	flag.Parse()
+
 	wcs.OpenServer()
 }
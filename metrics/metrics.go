@@ -0,0 +1,312 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Prometheus text format (0.0.4)으로 내보내는 metric 모음
+type Registry struct {
+	mu      sync.RWMutex
+	metrics []metric
+}
+
+type metric interface {
+	write(w io.Writer)
+}
+
+type desc struct {
+	name       string
+	help       string
+	typ        string
+	labelNames []string
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func (r *Registry) register(m metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.metrics = append(r.metrics, m)
+}
+
+// 등록한 순서대로 모든 metric을 씀
+func (r *Registry) WriteText(w io.Writer) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, m := range r.metrics {
+		m.write(w)
+	}
+}
+
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	r.WriteText(w)
+}
+
+func (d desc) writeHeader(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n", d.name, strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(d.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", d.name, d.typ)
+}
+
+// {a="x",b="y"}. extra는 histogram의 le처럼 뒤에 붙는 label
+func formatLabels(names []string, values []string, extra ...string) string {
+	pairs := []string{}
+	escaper := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	for i, name := range names {
+		pairs = append(pairs, name+`="`+escaper.Replace(values[i])+`"`)
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, extra[i]+`="`+escaper.Replace(extra[i+1])+`"`)
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+//
+//
+// Counter
+
+type Counter struct {
+	labelValues []string
+	bits        atomic.Uint64
+}
+
+func (c *Counter) Inc() {
+	c.Add(1)
+}
+
+// v는 음수가 아니어야 함
+func (c *Counter) Add(v float64) {
+	for {
+		old := c.bits.Load()
+		if c.bits.CompareAndSwap(old, math.Float64bits(math.Float64frombits(old)+v)) {
+			return
+		}
+	}
+}
+
+func (c *Counter) Value() float64 {
+	return math.Float64frombits(c.bits.Load())
+}
+
+// label 값마다 하나씩 있는 Counter
+type CounterVec struct {
+	desc
+	mu       sync.RWMutex
+	counters map[string]*Counter
+}
+
+func (r *Registry) NewCounterVec(name string, help string, labelNames ...string) *CounterVec {
+	cv := &CounterVec{desc: desc{name, help, "counter", labelNames}, counters: map[string]*Counter{}}
+	r.register(cv)
+	return cv
+}
+
+func (cv *CounterVec) With(labelValues ...string) *Counter {
+	if len(labelValues) != len(cv.labelNames) {
+		panic(fmt.Sprintf("%s: %d label values for %d labels", cv.name, len(labelValues), len(cv.labelNames)))
+	}
+	key := strings.Join(labelValues, "\xff")
+	cv.mu.RLock()
+	c, exist := cv.counters[key]
+	cv.mu.RUnlock()
+	if exist {
+		return c
+	}
+
+	cv.mu.Lock()
+	defer cv.mu.Unlock()
+	if c, exist = cv.counters[key]; !exist {
+		c = &Counter{labelValues: labelValues}
+		cv.counters[key] = c
+	}
+	return c
+}
+
+// label 값과 현재 값으로 f를 호출. label 값 순서로 정렬됨
+func (cv *CounterVec) Each(f func(labelValues []string, value float64)) {
+	for _, c := range cv.sorted() {
+		f(c.labelValues, c.Value())
+	}
+}
+
+// match가 true인 label 값을 가진 Counter들의 합. match가 nil이면 전체 합
+func (cv *CounterVec) Sum(match func(labelValues []string) bool) float64 {
+	sum := 0.0
+	cv.Each(func(labelValues []string, value float64) {
+		if match == nil || match(labelValues) {
+			sum += value
+		}
+	})
+	return sum
+}
+
+func (cv *CounterVec) sorted() []*Counter {
+	cv.mu.RLock()
+	keys := make([]string, 0, len(cv.counters))
+	for key := range cv.counters {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	counters := make([]*Counter, len(keys))
+	for i, key := range keys {
+		counters[i] = cv.counters[key]
+	}
+	cv.mu.RUnlock()
+	return counters
+}
+
+func (cv *CounterVec) write(w io.Writer) {
+	cv.writeHeader(w)
+	for _, c := range cv.sorted() {
+		fmt.Fprintf(w, "%s%s %s\n", cv.name, formatLabels(cv.labelNames, c.labelValues), formatValue(c.Value()))
+	}
+}
+
+//
+//
+// Gauge, Counter를 scrape할 때 f로 값을 구함
+
+type funcMetric struct {
+	desc
+	f func() (float64, bool)
+}
+
+func (r *Registry) NewGaugeFunc(name string, help string, f func() float64) {
+	r.register(&funcMetric{desc{name, help, "gauge", nil}, alwaysOK(f)})
+}
+
+// f가 false를 돌려주면 scrape 결과에서 빠지는 Gauge. 값을 싸게 구할 수 없는 경우에 사용
+func (r *Registry) NewOptionalGaugeFunc(name string, help string, f func() (float64, bool)) {
+	r.register(&funcMetric{desc{name, help, "gauge", nil}, f})
+}
+
+// 다른 곳에서 이미 세고 있는 누적 값 (예: Cache의 Stats)
+func (r *Registry) NewCounterFunc(name string, help string, f func() float64) {
+	r.register(&funcMetric{desc{name, help, "counter", nil}, alwaysOK(f)})
+}
+
+func alwaysOK(f func() float64) func() (float64, bool) {
+	return func() (float64, bool) { return f(), true }
+}
+
+func (fm *funcMetric) write(w io.Writer) {
+	value, ok := fm.f()
+	if !ok {
+		return
+	}
+	fm.writeHeader(w)
+	fmt.Fprintf(w, "%s %s\n", fm.name, formatValue(value))
+}
+
+//
+//
+// Histogram
+
+type Histogram struct {
+	labelValues []string
+	upperBounds []float64
+	mu          sync.Mutex
+	counts      []uint64 // upperBounds별 개수. 누적하지 않음
+	sum         float64
+	count       uint64
+}
+
+func (h *Histogram) Observe(v float64) {
+	i := sort.SearchFloat64s(h.upperBounds, v)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if i < len(h.counts) {
+		h.counts[i] += 1
+	}
+	h.sum += v
+	h.count += 1
+}
+
+type HistogramVec struct {
+	desc
+	upperBounds []float64
+	mu          sync.RWMutex
+	histograms  map[string]*Histogram
+}
+
+// buckets는 오름차순의 upper bound. +Inf는 자동으로 붙음
+func (r *Registry) NewHistogramVec(name string, help string, buckets []float64, labelNames ...string) *HistogramVec {
+	if !sort.Float64sAreSorted(buckets) {
+		panic(fmt.Sprintf("%s: buckets must be sorted", name))
+	}
+	hv := &HistogramVec{desc: desc{name, help, "histogram", labelNames}, upperBounds: buckets, histograms: map[string]*Histogram{}}
+	r.register(hv)
+	return hv
+}
+
+func (hv *HistogramVec) With(labelValues ...string) *Histogram {
+	if len(labelValues) != len(hv.labelNames) {
+		panic(fmt.Sprintf("%s: %d label values for %d labels", hv.name, len(labelValues), len(hv.labelNames)))
+	}
+	key := strings.Join(labelValues, "\xff")
+	hv.mu.RLock()
+	h, exist := hv.histograms[key]
+	hv.mu.RUnlock()
+	if exist {
+		return h
+	}
+
+	hv.mu.Lock()
+	defer hv.mu.Unlock()
+	if h, exist = hv.histograms[key]; !exist {
+		h = &Histogram{labelValues: labelValues, upperBounds: hv.upperBounds, counts: make([]uint64, len(hv.upperBounds))}
+		hv.histograms[key] = h
+	}
+	return h
+}
+
+func (hv *HistogramVec) write(w io.Writer) {
+	hv.writeHeader(w)
+	hv.mu.RLock()
+	keys := make([]string, 0, len(hv.histograms))
+	for key := range hv.histograms {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	histograms := make([]*Histogram, len(keys))
+	for i, key := range keys {
+		histograms[i] = hv.histograms[key]
+	}
+	hv.mu.RUnlock()
+
+	for _, h := range histograms {
+		h.mu.Lock()
+		cumulative := uint64(0)
+		for i, upperBound := range h.upperBounds {
+			cumulative += h.counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", hv.name, formatLabels(hv.labelNames, h.labelValues, "le", formatValue(upperBound)), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", hv.name, formatLabels(hv.labelNames, h.labelValues, "le", "+Inf"), h.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", hv.name, formatLabels(hv.labelNames, h.labelValues), formatValue(h.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", hv.name, formatLabels(hv.labelNames, h.labelValues), h.count)
+		h.mu.Unlock()
+	}
+}
@@ -0,0 +1,50 @@
+package metrics_test
+
+import (
+	"jnlee/metrics"
+	"strings"
+	"testing"
+)
+
+func TestRegistry(t *testing.T) {
+	registry := metrics.NewRegistry()
+	requests := registry.NewCounterVec("requests_total", "Requests.", "host", "result")
+	latency := registry.NewHistogramVec("latency_seconds", "Latency.", []float64{0.1, 1}, "host")
+	registry.NewGaugeFunc("entries", "Entries.", func() float64 { return 3 })
+	registry.NewOptionalGaugeFunc("unknown", "Not reported.", func() (float64, bool) { return 0, false })
+
+	requests.With("b.com", "hit").Inc()
+	requests.With("a.com", "miss").Add(2)
+	requests.With("a.com", "hit").Inc()
+	requests.With("a.com", "hit").Inc()
+	latency.With(`a"b`).Observe(0.1)
+	latency.With(`a"b`).Observe(0.5)
+	latency.With(`a"b`).Observe(2)
+
+	hits := requests.Sum(func(labelValues []string) bool { return labelValues[1] == "hit" })
+	if hits != 3 || requests.Sum(nil) != 5 {
+		t.Errorf("hits %v, total %v", hits, requests.Sum(nil))
+	}
+
+	want := `# HELP requests_total Requests.
+# TYPE requests_total counter
+requests_total{host="a.com",result="hit"} 2
+requests_total{host="a.com",result="miss"} 2
+requests_total{host="b.com",result="hit"} 1
+# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{host="a\"b",le="0.1"} 1
+latency_seconds_bucket{host="a\"b",le="1"} 2
+latency_seconds_bucket{host="a\"b",le="+Inf"} 3
+latency_seconds_sum{host="a\"b"} 2.6
+latency_seconds_count{host="a\"b"} 3
+# HELP entries Entries.
+# TYPE entries gauge
+entries 3
+`
+	var sb strings.Builder
+	registry.WriteText(&sb)
+	if sb.String() != want {
+		t.Errorf("WriteText() =\n%s\nwant\n%s", sb.String(), want)
+	}
+}
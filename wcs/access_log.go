@@ -0,0 +1,113 @@
+package wcs
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// AccessLogFormat
+const (
+	ACCESS_LOG_COMMON   string = "common"   // Apache common log format
+	ACCESS_LOG_COMBINED string = "combined" // common + Referer, User-Agent
+	ACCESS_LOG_JSON     string = "json"     // 요청마다 JSON 한 줄
+)
+
+// 운영 로그(myLogger)와 따로 요청마다 한 줄씩 기록
+var accessLogger *log.Logger
+
+type accessLogEntry struct {
+	Time        string  `json:"time"`
+	ClientIP    string  `json:"client_ip"`
+	Host        string  `json:"host"`
+	Method      string  `json:"method"`
+	URI         string  `json:"uri"`
+	Protocol    string  `json:"protocol"`
+	Status      int     `json:"status"`
+	Bytes       int64   `json:"bytes"`
+	CacheStatus string  `json:"cache_status"`
+	OriginTime  float64 `json:"origin_time"` // 초. Origin에 요청하지 않았으면 0
+	TotalTime   float64 `json:"total_time"`  // 초
+	CacheKey    string  `json:"cache_key"`
+	Referer     string  `json:"referer"`
+	UserAgent   string  `json:"user_agent"`
+}
+
+func validateAccessLogFormat(format string) error {
+	switch format {
+	case "", ACCESS_LOG_COMMON, ACCESS_LOG_COMBINED, ACCESS_LOG_JSON:
+		return nil
+	}
+	return fmt.Errorf("unknown AccessLogFormat %q", format)
+}
+
+func getAccessLogPath() string {
+	if path := GetConfig().AccessLogPath; path != "" {
+		return path
+	}
+	return WCS_PATH + "access_log.txt"
+}
+
+func logAccess(state *requestState, r *http.Request, cw *countingResponseWriter, totalTime time.Duration) {
+	format := GetConfig().AccessLogFormat
+	if accessLogger == nil || format == "" {
+		return
+	}
+	clientIP, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		clientIP = r.RemoteAddr
+	}
+	entry := accessLogEntry{
+		Time:        time.Now().Format(time.RFC3339),
+		ClientIP:    clientIP,
+		Host:        r.Host,
+		Method:      r.Method,
+		URI:         r.URL.RequestURI(),
+		Protocol:    r.Proto,
+		Status:      cw.status,
+		Bytes:       cw.written,
+		CacheStatus: strings.ToUpper(state.result),
+		OriginTime:  state.originTime.Seconds(),
+		TotalTime:   totalTime.Seconds(),
+		CacheKey:    state.sha256,
+		Referer:     r.Referer(),
+		UserAgent:   r.UserAgent(),
+	}
+	if entry.Status == 0 {
+		entry.Status = http.StatusOK
+	}
+	accessLogger.Println(formatAccessLog(entry, format))
+}
+
+// common, combined는 Apache 형식 뒤에 캐시 상태, Origin 시간, 전체 시간, 캐시 key를 붙임
+func formatAccessLog(entry accessLogEntry, format string) string {
+	if format == ACCESS_LOG_JSON {
+		line, _ := json.Marshal(entry)
+		return string(line)
+	}
+
+	quote := func(value string) string {
+		if value == "" {
+			return `"-"`
+		}
+		return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(value) + `"`
+	}
+	bytes := "-"
+	if entry.Bytes > 0 {
+		bytes = fmt.Sprint(entry.Bytes)
+	}
+	logTime, _ := time.Parse(time.RFC3339, entry.Time)
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%s - - [%s] %s %d %s", entry.ClientIP, logTime.Format("02/Jan/2006:15:04:05 -0700"),
+		quote(entry.Method+" "+entry.URI+" "+entry.Protocol), entry.Status, bytes)
+	if format == ACCESS_LOG_COMBINED {
+		fmt.Fprintf(&sb, " %s %s", quote(entry.Referer), quote(entry.UserAgent))
+	}
+	fmt.Fprintf(&sb, " %s %.3f %.3f %s", entry.CacheStatus, entry.OriginTime, entry.TotalTime, entry.CacheKey)
+	return sb.String()
+}
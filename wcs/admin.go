@@ -0,0 +1,118 @@
+package wcs
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// AdminAllowedIPs의 IP 또는 CIDR
+func parseAllowedIPs(allowedIPs []string) ([]netip.Prefix, error) {
+	prefixes := []netip.Prefix{}
+	for _, value := range allowedIPs {
+		if !strings.Contains(value, "/") {
+			addr, err := netip.ParseAddr(value)
+			if err != nil {
+				return nil, fmt.Errorf("AdminAllowedIPs %q: %v", value, err)
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(value)
+		if err != nil {
+			return nil, fmt.Errorf("AdminAllowedIPs %q: %v", value, err)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+func isAdminIPAllowed(remoteAddr string) bool {
+	prefixes := live.Load().adminIPs
+	if len(prefixes) == 0 {
+		return true
+	}
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return false
+	}
+	for _, prefix := range prefixes {
+		if prefix.Contains(addr.Unmap()) {
+			return true
+		}
+	}
+	return false
+}
+
+// 관리용 요청을 보낸 사람. Basic은 사용자 이름, Bearer는 몇 번째 token인지로 구분.
+// 인증을 설정하지 않았으면 ok = true, name = ""
+func getAdminName(r *http.Request) (name string, ok bool) {
+	config := GetConfig()
+	if len(config.AdminTokens) == 0 && len(config.AdminUsers) == 0 {
+		return "", true
+	}
+
+	if user, password, isBasic := r.BasicAuth(); isBasic {
+		stored, exist := config.AdminUsers[user]
+		if exist && subtle.ConstantTimeCompare([]byte(password), []byte(stored)) == 1 {
+			return user, true
+		}
+		return "", false
+	}
+	scheme, token, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !strings.EqualFold(scheme, "Bearer") || token == "" {
+		return "", false
+	}
+	for i, stored := range config.AdminTokens {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(stored)) == 1 {
+			return fmt.Sprintf("token#%d", i+1), true
+		}
+	}
+	return "", false
+}
+
+// 관리용 요청을 보낸 사람과 주소. 로그에 남길 때 사용
+func getRequester(r *http.Request) string {
+	if name, _ := getAdminName(r); name != "" {
+		return name + "@" + r.RemoteAddr
+	}
+	return r.RemoteAddr
+}
+
+// 허용되지 않은 IP면 403, 인증에 실패하면 401로 응답하고 false를 돌려줌
+func authorizeAdmin(w http.ResponseWriter, r *http.Request) bool {
+	if !isAdminIPAllowed(r.RemoteAddr) {
+		myLogger.Warnf("Admin forbidden : %s %s\n", r.RemoteAddr, r.URL.Path)
+		w.WriteHeader(http.StatusForbidden)
+		return false
+	}
+	if _, ok := getAdminName(r); !ok {
+		myLogger.Warnf("Admin unauthorized : %s %s\n", r.RemoteAddr, r.URL.Path)
+		config := GetConfig()
+		if len(config.AdminUsers) > 0 {
+			w.Header().Add("WWW-Authenticate", `Basic realm="wcs"`)
+		}
+		if len(config.AdminTokens) > 0 {
+			w.Header().Add("WWW-Authenticate", `Bearer realm="wcs"`)
+		}
+		w.WriteHeader(http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+// pprof도 관리용 요청과 같은 인증을 거침
+func adminHandler(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if authorizeAdmin(w, r) {
+			handler.ServeHTTP(w, r)
+		}
+	})
+}
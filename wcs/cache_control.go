@@ -0,0 +1,99 @@
+package wcs
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Cache-Control의 directive 이름(소문자)과 값. 값이 없는 directive는 빈 문자열
+type CacheControl map[string]string
+
+// Cache-Control 헤더 값 파싱 (RFC 9111 5.2)
+// directive는 ','로 구분하며, 잘못된 값에 대비해 따옴표 밖의 공백도 구분자로 취급함
+func ParseCacheControl(value string) CacheControl {
+	cc := CacheControl{}
+	for len(value) > 0 {
+		var name, directiveValue string
+		value = strings.TrimLeft(value, ", \t")
+		end := strings.IndexAny(value, "=, \t")
+		if end < 0 {
+			end = len(value)
+		}
+		name, value = strings.ToLower(value[:end]), value[end:]
+
+		if strings.HasPrefix(value, "=") {
+			directiveValue, value = parseDirectiveValue(value[1:])
+		}
+		if _, exist := cc[name]; name != "" && !exist {
+			cc[name] = directiveValue
+		}
+	}
+	return cc
+}
+
+// token 또는 quoted-string 하나를 읽고 나머지를 돌려줌
+func parseDirectiveValue(value string) (string, string) {
+	if !strings.HasPrefix(value, `"`) {
+		end := strings.IndexAny(value, ", \t")
+		if end < 0 {
+			return value, ""
+		}
+		return value[:end], value[end:]
+	}
+
+	var sb strings.Builder
+	for i := 1; i < len(value); i++ {
+		switch value[i] {
+		case '\\':
+			if i+1 < len(value) {
+				i++
+				sb.WriteByte(value[i])
+			}
+		case '"':
+			return sb.String(), value[i+1:]
+		default:
+			sb.WriteByte(value[i])
+		}
+	}
+	return sb.String(), ""
+}
+
+func GetCacheControl(header http.Header) CacheControl {
+	return ParseCacheControl(strings.Join(header.Values("Cache-Control"), ","))
+}
+
+func (cc CacheControl) Has(name string) bool {
+	_, exist := cc[name]
+	return exist
+}
+
+// max-age=60 같은 delta-seconds 값
+func (cc CacheControl) Seconds(name string) (int, bool) {
+	value, exist := cc[name]
+	if !exist {
+		return 0, false
+	}
+	seconds, err := strconv.Atoi(value)
+	if err != nil || seconds < 0 {
+		return 0, false
+	}
+	return seconds, true
+}
+
+// no-cache="Set-Cookie, X-Token" 같이 헤더 이름 목록이 붙은 directive의 헤더 이름들
+func (cc CacheControl) FieldNames(name string) []string {
+	var fieldNames []string
+	for _, fieldName := range strings.Split(cc[name], ",") {
+		if fieldName = strings.TrimSpace(fieldName); fieldName != "" {
+			fieldNames = append(fieldNames, http.CanonicalHeaderKey(fieldName))
+		}
+	}
+	return fieldNames
+}
+
+// 헤더 이름 목록 없이 쓰인 directive인지. no-cache는 응답 전체, no-cache="X"는 X 헤더에만 적용됨
+func (cc CacheControl) isUnqualified(name string) bool {
+	value, exist := cc[name]
+	return exist && value == ""
+}
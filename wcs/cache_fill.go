@@ -0,0 +1,190 @@
+package wcs
+
+import (
+	"compress/gzip"
+	"errors"
+	"io"
+	"jnlee/cache"
+	"net/http"
+	"time"
+)
+
+var (
+	errFileSizeOver = errors.New("file size over")
+	errIncomplete   = errors.New("incomplete body") // Client 연결이 끊기는 등 본문을 끝까지 받지 못함
+)
+
+// Origin 응답 본문을 Client에 보내면서 같은 내용을 캐시에 저장.
+// 압축된 응답은 압축을 풀어서 저장하고, MaxFileSize를 넘으면 저장을 포기하고 보내기만 함
+type cacheFill struct {
+	body     io.ReadCloser
+	sink     io.Writer // cw 또는 압축을 푸는 goroutine으로 가는 pipe
+	cw       cache.CacheWriter
+	encoders []io.WriteCloser // 미리 압축해서 저장할 본문
+	pipe     *io.PipeWriter
+	gunzipC  chan error
+	state    *requestState
+	url      string
+	err      error // 저장을 포기한 이유
+	done     bool
+}
+
+// 본문 크기가 limit을 넘으면 errFileSizeOver
+type limitWriter struct {
+	w       io.Writer
+	limit   int64
+	written int64
+}
+
+func (lw *limitWriter) Write(p []byte) (int, error) {
+	lw.written += int64(len(p))
+	if lw.written > lw.limit {
+		return 0, errFileSizeOver
+	}
+	return lw.w.Write(p)
+}
+
+// 저장할 CacheItem을 만들고 resp.Body를 cacheFill로 바꿈. 저장을 시작하지 못하면 false
+func startCacheFill(resp *http.Response, state *requestState) bool {
+	hashKey, sha256, ci := newCacheItem(resp, state)
+	cw, err := myCache.NewWriter(hashKey, sha256, ci)
+	if err != nil {
+		myLogger.Errorf("Cache writer error : %s (%v)\n", state.url, err)
+		return false
+	}
+
+	state.stored = true
+	state.expirationTime = ci.ExpirationTime
+	fill := &cacheFill{body: resp.Body, cw: cw, state: state, url: state.url}
+	writers := []io.Writer{cw}
+	for _, encoding := range getEncodings(ci.Header, state.vhost) {
+		encoder := newEncoder(encoding, cw.Encoded(encoding))
+		fill.encoders = append(fill.encoders, encoder)
+		writers = append(writers, encoder)
+	}
+	lw := &limitWriter{w: io.MultiWriter(writers...), limit: GetConfig().MaxFileSize}
+	fill.sink = lw
+	if resp.Header.Get("Content-Encoding") == GZIP {
+		pr, pw := io.Pipe()
+		fill.sink, fill.pipe = pw, pw
+		fill.gunzipC = make(chan error, 1)
+		go gunzipTo(lw, pr, fill.gunzipC)
+	}
+	resp.Body = fill
+	return true
+}
+
+func gunzipTo(w io.Writer, pr *io.PipeReader, errC chan<- error) {
+	reader, err := gzip.NewReader(pr)
+	if err == nil {
+		_, err = io.Copy(w, reader)
+		reader.Close()
+	}
+	// 실패하면 pipe에 쓰는 쪽도 에러를 받아서 저장을 포기함
+	pr.CloseWithError(err)
+	errC <- err
+}
+
+func (fill *cacheFill) Read(p []byte) (int, error) {
+	n, err := fill.body.Read(p)
+	if n > 0 && fill.err == nil && !fill.done {
+		if _, werr := fill.sink.Write(p[:n]); werr != nil {
+			fill.abort(werr)
+		}
+	}
+	if err == io.EOF {
+		fill.commit()
+	}
+	return n, err
+}
+
+func (fill *cacheFill) Close() error {
+	fill.abort(errIncomplete)
+	return fill.body.Close()
+}
+
+func (fill *cacheFill) commit() {
+	if fill.err != nil || fill.done {
+		return
+	}
+	if err := fill.closePipe(nil); err != nil {
+		fill.abort(err)
+		return
+	}
+	fill.done = true
+
+	fill.closeEncoders()
+	err := fill.cw.Commit()
+	if err != nil {
+		myLogger.Errorf("Cache commit error : %s (%v)\n", fill.url, err)
+		return
+	}
+	cachedTotal.With(fill.state.host).Inc()
+}
+
+func (fill *cacheFill) abort(err error) {
+	if fill.err != nil || fill.done {
+		return
+	}
+	fill.err = err
+	if gunzipErr := fill.closePipe(err); errors.Is(gunzipErr, errFileSizeOver) {
+		err = gunzipErr
+	}
+	fill.closeEncoders()
+	fill.cw.Abort()
+
+	if errors.Is(err, errFileSizeOver) {
+		myLogger.Warnf("File size over : %s\n", fill.url)
+		increaseNotCached(fill.state, REASON_FILE_SIZE)
+		return
+	}
+	myLogger.Warnf("Cache fill aborted : %s (%v)\n", fill.url, err)
+}
+
+func (fill *cacheFill) closeEncoders() {
+	for _, encoder := range fill.encoders {
+		encoder.Close()
+	}
+}
+
+// 압축을 푸는 goroutine을 끝내고 그 결과를 돌려줌. err가 nil이면 본문을 끝까지 받은 것
+func (fill *cacheFill) closePipe(err error) error {
+	if fill.pipe == nil {
+		return nil
+	}
+	fill.pipe.CloseWithError(err)
+	fill.pipe = nil
+	return <-fill.gunzipC
+}
+
+// 본문을 제외한 저장 정보와 key
+func newCacheItem(resp *http.Response, state *requestState) (hashKey int, sha256 string, ci cache.CacheItem) {
+	header := resp.Header.Clone()
+	// 저장하는 본문은 압축이 풀려 있음. gzip 외의 압축은 isCacheable에서 걸러짐
+	if encoding := header.Get("Content-Encoding"); encoding == GZIP || encoding == "identity" {
+		header.Del("Content-Encoding")
+	}
+	header.Del("Content-Length")
+	cc := GetCacheControl(header)
+	for _, fieldName := range append(cc.FieldNames("no-cache"), cc.FieldNames("private")...) {
+		header.Del(fieldName)
+	}
+
+	responseTime := time.Now()
+	ci = cache.CacheItem{
+		Header:         header,
+		URL:            state.url,
+		Host:           state.host,
+		ExpirationTime: GetExpirationTime(header, state.vhost.config, responseTime),
+		CachedTime:     responseTime,
+		InitialAge:     GetInitialAge(header, responseTime),
+	}
+	vary, _ := ParseVary(header)
+	hashKey, sha256 = setVariantKey(&ci, state, vary, resp.Request.Header)
+
+	switch GetConfig().StoreType {
+	case STORE_TYPE_FILE, STORE_TYPE_MEMORY_FILE:
+		ci.Filepath = WCS_PATH + getCacheDir(state.vhost.config) + "/" + sha256
+	}
+	return hashKey, sha256, ci
+}
@@ -0,0 +1,73 @@
+package wcs
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Cache-Status 헤더에 쓰는 캐시 이름 (RFC 9211)
+const CACHE_STATUS_NAME string = "jnlee"
+
+// Origin으로 요청을 보낸 이유 (Cache-Status의 fwd)
+const (
+	FWD_URI_MISS string = "uri-miss" // 저장된 캐시가 없음
+	FWD_STALE    string = "stale"    // 저장된 캐시가 만료됨
+	FWD_BYPASS   string = "bypass"   // 캐시를 사용하지 않는 method
+	FWD_REQUEST  string = "request"  // 만료되지 않았지만 Client가 no-cache, max-age=0으로 재검증을 요청함
+)
+
+// 요청 처리 결과를 Cache-Status 헤더로 씀. 응답 헤더를 보내기 전에 호출해야 함.
+// Origin 앞에 다른 캐시가 있으면 그 값 뒤에 붙임 (Client에 가까운 캐시가 마지막)
+func setCacheStatusHeader(header http.Header, state *requestState, r *http.Request) {
+	debug := isCacheDebugAllowed(state, r)
+	header.Add("Cache-Status", getCacheStatus(state, debug))
+	if GetConfig().XCacheEnabled {
+		header.Set("X-Cache", strings.ToUpper(state.result))
+	}
+	if debug {
+		header.Set("X-Cache-Key", state.uri)
+	}
+}
+
+// jnlee; hit; ttl=30 또는 jnlee; fwd=uri-miss; fwd-status=200; ttl=60; stored
+func getCacheStatus(state *requestState, debug bool) string {
+	params := []string{CACHE_STATUS_NAME}
+	if state.fwd == "" {
+		params = append(params, "hit")
+	} else {
+		params = append(params, "fwd="+state.fwd)
+		if state.fwdStatus != 0 {
+			params = append(params, "fwd-status="+strconv.Itoa(state.fwdStatus))
+		}
+	}
+	// 만료된 캐시로 응답한 경우 음수
+	if !state.expirationTime.IsZero() {
+		ttl := int(math.Floor(time.Until(state.expirationTime).Seconds()))
+		params = append(params, "ttl="+strconv.Itoa(ttl))
+	}
+	if state.stored {
+		params = append(params, "stored")
+	}
+	if debug {
+		params = append(params, `key="`+state.sha256+`"`)
+	}
+	if state.notCachedReason != "" {
+		params = append(params, `detail="`+state.notCachedReason+`"`)
+	}
+	return strings.Join(params, "; ")
+}
+
+// Host의 CacheDebugEnabled가 켜져 있으면 캐시 key를 보냄.
+// CacheDebugInternalOnly이면 AdminAllowedIPs에 있는 Client에만 보냄. AdminAllowedIPs가 비어 있으면 아무에게도 보내지 않음
+func isCacheDebugAllowed(state *requestState, r *http.Request) bool {
+	if !state.vhost.config.CacheDebugEnabled || r == nil {
+		return false
+	}
+	if !GetConfig().CacheDebugInternalOnly {
+		return true
+	}
+	return len(live.Load().adminIPs) > 0 && isAdminIPAllowed(r.RemoteAddr)
+}
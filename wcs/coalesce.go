@@ -0,0 +1,79 @@
+package wcs
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+var (
+	inFlight      = map[string]*flight{}
+	inFlightMutex sync.Mutex
+)
+
+// 같은 key에 대해 Origin으로 가고 있는 요청 하나
+type flight struct {
+	key  string
+	done chan struct{}
+	once sync.Once
+}
+
+// 이미 같은 key의 요청이 Origin으로 가고 있으면 그 flight를, 없으면 새 flight를 만들어 돌려줌
+func joinFlight(key string) (f *flight, isLeader bool) {
+	inFlightMutex.Lock()
+	defer inFlightMutex.Unlock()
+
+	if f, exist := inFlight[key]; exist {
+		return f, false
+	}
+	f = &flight{key: key, done: make(chan struct{})}
+	inFlight[key] = f
+	return f, true
+}
+
+func (f *flight) finish() {
+	f.once.Do(func() {
+		inFlightMutex.Lock()
+		if inFlight[f.key] == f {
+			delete(inFlight, f.key)
+		}
+		inFlightMutex.Unlock()
+		close(f.done)
+	})
+}
+
+// 캐시 miss일 때 같은 key의 요청이 Origin에 가 있으면 그 응답이 캐시될 때까지 기다림.
+// 기다렸다면 true를 돌려주며, 호출한 쪽은 캐시를 다시 확인해야 함.
+// 처음 온 요청은 flight의 leader가 되어 Origin으로 감
+func waitForFlight(state *requestState, r *http.Request) bool {
+	timeout := time.Millisecond * time.Duration(GetConfig().CoalescingWaitTimeout)
+	// GET으로 바꾸지 않는 HEAD는 캐시를 채우지 못하므로 leader가 되면 안 됨
+	isFilling := r.Method == http.MethodGet || (r.Method == http.MethodHead && GetConfig().HeadUpgradeEnabled)
+	if timeout <= 0 || !isFilling {
+		return false
+	}
+
+	f, isLeader := joinFlight(state.sha256)
+	if isLeader {
+		state.flight = f
+		return false
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-f.done:
+	case <-timer.C:
+		myLogger.Warnf("Coalescing wait timeout : %s\n", state.url)
+	case <-r.Context().Done():
+		// Client 연결이 끊기면 leader를 더 기다리지 않음
+	}
+	return true
+}
+
+// leader의 응답이 캐시에 저장됐거나 저장되지 않는 것으로 결정된 후 호출
+func (state *requestState) finishFlight() {
+	if state.flight != nil {
+		state.flight.finish()
+	}
+}
@@ -0,0 +1,298 @@
+package wcs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"jnlee/cache"
+	"net/http"
+	"net/http/httputil"
+	"net/netip"
+	"os"
+	"os/signal"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+var (
+	live          atomic.Pointer[liveConfig]
+	reloadMutex   sync.Mutex
+	cleanupResetC = make(chan struct{}, 1)
+)
+
+type ConfigStruct struct {
+	MaxFileSize            int64             `json:"MaxFileSize"`
+	GzipEnabled            bool              `json:"GzipEnabled"`
+	CompressEncodings      []string          `json:"CompressEncodings"`
+	HitHeaderAllowList     []string          `json:"HitHeaderAllowList"`
+	HitHeaderDenyList      []string          `json:"HitHeaderDenyList"`
+	CacheExceptions        []string          `json:"CacheExceptions"`
+	QueryIgnoreEnabled     bool              `json:"QueryIgnoreEnabled"`
+	QuerySortingEnabled    bool              `json:"QuerySortingEnabled"`
+	ResTimeLoggingEnabled  bool              `json:"ResponseTimeLoggingEnabled"`
+	XCacheEnabled          bool              `json:"XCacheEnabled"`          // X-Cache 헤더도 보냄
+	CacheDebugInternalOnly bool              `json:"CacheDebugInternalOnly"` // 캐시 key는 AdminAllowedIPs의 Client에만 보냄
+	AccessLogFormat        string            `json:"AccessLogFormat"`        // "common", "combined", "json". 빈 문자열이면 기록하지 않음
+	AccessLogPath          string            `json:"AccessLogPath"`
+	LogLevel               string            `json:"LogLevel"`          // "debug", "info", "warn", "error". 기본값 info
+	LogMaxSize             int64             `json:"LogMaxSize"`        // 로그 파일을 보관하고 새로 쓰는 크기 (MB). 0이면 크기로 나누지 않음
+	LogRotateInterval      int               `json:"LogRotateInterval"` // 로그 파일을 보관하고 새로 쓰는 주기 (초). 0이면 시간으로 나누지 않음
+	LogMaxBackups          int               `json:"LogMaxBackups"`     // 보관할 로그 파일 수. 0이면 모두 보관
+	LogCompress            bool              `json:"LogCompress"`       // 보관한 로그 파일을 gzip으로 압축
+	CleanupFrequency       int               `json:"CleanupFrequency"`
+	StaleRetention         int               `json:"StaleRetention"`
+	HeuristicFreshPercent  int               `json:"HeuristicFreshnessPercent"`
+	CoalescingWaitTimeout  int               `json:"CoalescingWaitTimeout"`
+	ShutdownTimeout        int               `json:"ShutdownTimeout"` // 종료할 때 처리 중인 요청과 캐시 저장 작업을 기다리는 시간 (초)
+	HeadUpgradeEnabled     bool              `json:"HeadUpgradeEnabled"`
+	EvictionPolicy         string            `json:"EvictionPolicy"`
+	MaxCacheBytes          int64             `json:"MaxCacheBytes"`
+	MaxCacheItems          int               `json:"MaxCacheItems"`
+	MemoryCacheBytes       int64             `json:"MemoryCacheBytes"`
+	MemoryCacheItems       int               `json:"MemoryCacheItems"`
+	StoreType              string            `json:"StoreType"`
+	AdminTokens            []string          `json:"AdminTokens"`     // Authorization: Bearer <token>
+	AdminUsers             map[string]string `json:"AdminUsers"`      // Basic 인증. 사용자 이름 -> 비밀번호
+	AdminAllowedIPs        []string          `json:"AdminAllowedIPs"` // 관리용 요청을 보낼 수 있는 IP 또는 CIDR
+	PprofAddr              string            `json:"PprofAddr"`
+	Hosts                  []HostConfig      `json:"Hosts"`
+}
+
+// 프록시가 받는 Host 하나와 그 Host의 Origin 서버 설정
+type HostConfig struct {
+	Host        string `json:"Host"`
+	Origin      string `json:"Origin"` // scheme://host[:port][/prefix]
+	GzipEnabled bool   `json:"GzipEnabled"`
+	DefaultTTL  int    `json:"DefaultTTL"` // 유효시간을 알 수 없는 응답의 유효시간 (초)
+	// Cache-Status에 캐시 key를 붙이고 X-Cache-Key로 GetURI 값을 보냄
+	CacheDebugEnabled bool `json:"CacheDebugEnabled"`
+	// StoreType이 file일 때 본문을 저장할 WCS_PATH 아래 디렉토리 이름. 없으면 log_body
+	CacheDir string `json:"CacheDir"`
+	// Origin이 stale-while-revalidate, stale-if-error를 보내지 않은 경우의 기본값 (초)
+	StaleWhileRevalidate int `json:"StaleWhileRevalidate"`
+	StaleIfError         int `json:"StaleIfError"`
+}
+
+type virtualHost struct {
+	config HostConfig
+	proxy  *httputil.ReverseProxy
+}
+
+// 현재 적용 중인 Config와 Config로부터 만들어진 값들. 교체할 때는 통째로 바꿈
+type liveConfig struct {
+	config          ConfigStruct
+	cacheExceptions []*regexp.Regexp
+	adminIPs        []netip.Prefix
+	hosts           map[string]*virtualHost
+	loadedTime      time.Time
+}
+
+func GetConfig() *ConfigStruct {
+	return &live.Load().config
+}
+
+// 검증 후 적용. 잘못된 Config라면 기존 Config를 유지
+func SetConfig(config ConfigStruct) error {
+	lc, err := newLiveConfig(config)
+	if err != nil {
+		return err
+	}
+	live.Store(lc)
+	return nil
+}
+
+func getVirtualHost(host string) (*virtualHost, bool) {
+	vhost, ok := live.Load().hosts[host]
+	return vhost, ok
+}
+
+func getConfigLoadedTime() time.Time {
+	return live.Load().loadedTime
+}
+
+func newLiveConfig(config ConfigStruct) (*liveConfig, error) {
+	if config.MaxFileSize <= 0 {
+		return nil, fmt.Errorf("MaxFileSize must be positive")
+	}
+	if config.CleanupFrequency <= 0 {
+		return nil, fmt.Errorf("CleanupFrequency must be positive")
+	}
+	if config.StaleRetention < 0 {
+		return nil, fmt.Errorf("StaleRetention must not be negative")
+	}
+	if config.HeuristicFreshPercent < 0 || config.HeuristicFreshPercent > 100 {
+		return nil, fmt.Errorf("HeuristicFreshnessPercent must be between 0 and 100")
+	}
+	if config.CoalescingWaitTimeout < 0 {
+		return nil, fmt.Errorf("CoalescingWaitTimeout must not be negative")
+	}
+	if config.ShutdownTimeout < 0 {
+		return nil, fmt.Errorf("ShutdownTimeout must not be negative")
+	}
+	if err := validateCompressEncodings(config.CompressEncodings); err != nil {
+		return nil, err
+	}
+	if err := validateAccessLogFormat(config.AccessLogFormat); err != nil {
+		return nil, err
+	}
+	if err := validateLogConfig(config); err != nil {
+		return nil, err
+	}
+	if config.MaxCacheBytes < 0 || config.MaxCacheItems < 0 {
+		return nil, fmt.Errorf("MaxCacheBytes, MaxCacheItems must not be negative")
+	}
+	if config.EvictionPolicy != "" {
+		if _, err := cache.NewEvictionPolicy(config.EvictionPolicy); err != nil {
+			return nil, err
+		}
+	}
+	switch config.StoreType {
+	case STORE_TYPE_FILE, STORE_TYPE_REDIS:
+	case STORE_TYPE_MEMORY_FILE, STORE_TYPE_MEMORY_REDIS:
+		if config.MemoryCacheBytes <= 0 && config.MemoryCacheItems <= 0 {
+			return nil, fmt.Errorf("MemoryCacheBytes or MemoryCacheItems must be set for StoreType %q", config.StoreType)
+		}
+	default:
+		return nil, fmt.Errorf("unknown StoreType %q", config.StoreType)
+	}
+	if config.MemoryCacheBytes < 0 || config.MemoryCacheItems < 0 {
+		return nil, fmt.Errorf("MemoryCacheBytes, MemoryCacheItems must not be negative")
+	}
+
+	adminIPs, err := parseAllowedIPs(config.AdminAllowedIPs)
+	if err != nil {
+		return nil, err
+	}
+
+	lc := &liveConfig{
+		config:     config,
+		adminIPs:   adminIPs,
+		hosts:      make(map[string]*virtualHost),
+		loadedTime: time.Now(),
+	}
+	for _, pattern := range config.CacheExceptions {
+		compiledPattern, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("CacheExceptions %q: %v", pattern, err)
+		}
+		lc.cacheExceptions = append(lc.cacheExceptions, compiledPattern)
+	}
+	for _, hc := range config.Hosts {
+		if _, exist := lc.hosts[hc.Host]; exist || hc.Host == "" || hc.Host == CUSTOM_HOST {
+			return nil, fmt.Errorf("invalid or duplicated Host %q", hc.Host)
+		}
+		if hc.DefaultTTL < 0 || hc.StaleWhileRevalidate < 0 || hc.StaleIfError < 0 {
+			return nil, fmt.Errorf("Host %q: DefaultTTL, StaleWhileRevalidate, StaleIfError must not be negative", hc.Host)
+		}
+		if dir := hc.CacheDir; dir != "" && (dir == "." || dir == ".." || strings.ContainsAny(dir, `/\`)) {
+			return nil, fmt.Errorf("Host %q: invalid CacheDir %q", hc.Host, dir)
+		}
+		proxy, err := getReverseProxy(hc.Origin)
+		if err != nil {
+			return nil, fmt.Errorf("Host %q: %v", hc.Host, err)
+		}
+		lc.hosts[hc.Host] = &virtualHost{hc, proxy}
+	}
+	return lc, nil
+}
+
+func readConfigFile() (ConfigStruct, error) {
+	config := ConfigStruct{}
+	configData, err := os.ReadFile(CONFIG_PATH)
+	if err != nil {
+		return config, err
+	}
+	err = json.Unmarshal(configData, &config)
+	return config, err
+}
+
+func loadConfig() {
+	config, err := readConfigFile()
+	if err != nil {
+		panic(err)
+	}
+	err = SetConfig(config)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// config.json을 다시 읽어 서버 재시작 없이 적용
+func reloadConfig() error {
+	reloadMutex.Lock()
+	defer reloadMutex.Unlock()
+
+	config, err := readConfigFile()
+	if err != nil {
+		return err
+	}
+	oldConfig := GetConfig()
+	if config.StoreType != oldConfig.StoreType {
+		return fmt.Errorf("StoreType cannot be changed without restart")
+	}
+	if config.EvictionPolicy != oldConfig.EvictionPolicy {
+		return fmt.Errorf("EvictionPolicy cannot be changed without restart")
+	}
+	if config.PprofAddr != oldConfig.PprofAddr {
+		return fmt.Errorf("PprofAddr cannot be changed without restart")
+	}
+	if config.AccessLogPath != oldConfig.AccessLogPath {
+		return fmt.Errorf("AccessLogPath cannot be changed without restart")
+	}
+	err = SetConfig(config)
+	if err != nil {
+		return err
+	}
+
+	if bc, ok := myCache.(*cache.BoundedCache); ok {
+		bc.SetLimits(config.MaxCacheBytes, config.MaxCacheItems)
+	}
+	if tc, ok := getTieredCache(); ok {
+		tc.Memory.(*cache.BoundedCache).SetLimits(config.MemoryCacheBytes, config.MemoryCacheItems)
+	}
+
+	if config.CleanupFrequency != oldConfig.CleanupFrequency {
+		select {
+		case cleanupResetC <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+func watchReloadSignal(ctx context.Context) {
+	signalC := make(chan os.Signal, 1)
+	signal.Notify(signalC, syscall.SIGHUP)
+	defer signal.Stop(signalC)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-signalC:
+		}
+		if err := reloadConfig(); err != nil {
+			myLogger.Errorf("Config reload failed (SIGHUP) : %v\n", err)
+			continue
+		}
+		myLogger.Infof("Config reloaded (SIGHUP)\n")
+	}
+}
+
+func handleReload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if err := reloadConfig(); err != nil {
+		myLogger.Errorf("Config reload failed : %v\n", err)
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "Reload Failed! (%v)\n", err)
+		return
+	}
+	myLogger.Infof("Config reloaded (%s)\n", getRequester(r))
+	fmt.Fprintf(w, "Reload Success! (%s)\n", getConfigLoadedTime().Format(time.DateTime))
+}
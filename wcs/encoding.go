@@ -0,0 +1,146 @@
+package wcs
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"jnlee/cache"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	BROTLI string = "br"
+	ZSTD   string = "zstd"
+)
+
+// 캐시를 저장할 때 미리 압축해두는 Content-Encoding. Config에 없으면 gzip만 사용
+func getCompressEncodings() []string {
+	if encodings := GetConfig().CompressEncodings; len(encodings) > 0 {
+		return encodings
+	}
+	return []string{GZIP}
+}
+
+func validateCompressEncodings(encodings []string) error {
+	for _, encoding := range encodings {
+		if encoding != GZIP && encoding != BROTLI && encoding != ZSTD {
+			return fmt.Errorf("unknown CompressEncodings %q", encoding)
+		}
+	}
+	return nil
+}
+
+// 압축해서 보낼 수 있는 캐시이면 사용할 수 있는 Content-Encoding들. no-transform이면 받은 그대로 보냄
+func getEncodings(header http.Header, vhost *virtualHost) []string {
+	if !GetConfig().GzipEnabled || !vhost.config.GzipEnabled || GetCacheControl(header).Has("no-transform") {
+		return nil
+	}
+	return getCompressEncodings()
+}
+
+func newEncoder(encoding string, w io.Writer) io.WriteCloser {
+	switch encoding {
+	case BROTLI:
+		return brotli.NewWriter(w)
+	case ZSTD:
+		encoder, _ := zstd.NewWriter(w, zstd.WithEncoderConcurrency(1))
+		return encoder
+	}
+	return gzip.NewWriter(w)
+}
+
+// Accept-Encoding의 coding별 q값 (RFC 9110 12.5.3). 잘못된 q값을 가진 항목은 무시
+func ParseAcceptEncoding(acceptEncoding string) map[string]float64 {
+	qValues := map[string]float64{}
+	for _, element := range strings.Split(acceptEncoding, ",") {
+		coding, params, _ := strings.Cut(element, ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding == "" {
+			continue
+		}
+
+		q := 1.0
+		if name, value, ok := strings.Cut(strings.TrimSpace(params), "="); ok && strings.EqualFold(strings.TrimSpace(name), "q") {
+			var err error
+			q, err = strconv.ParseFloat(strings.TrimSpace(value), 64)
+			if err != nil || q < 0 || q > 1 {
+				continue
+			}
+		}
+		if _, exist := qValues[coding]; !exist {
+			qValues[coding] = q
+		}
+	}
+	return qValues
+}
+
+// encodings 중 Client가 가장 선호하는 Content-Encoding. q값이 같으면 encodings의 순서를 따르고,
+// 압축하지 않는 편이 낫거나 받을 수 있는 것이 없으면 ""
+func SelectEncoding(acceptEncoding string, encodings []string) string {
+	if strings.TrimSpace(acceptEncoding) == "" {
+		return ""
+	}
+	qValues := ParseAcceptEncoding(acceptEncoding)
+	getQ := func(coding string, defaultQ float64) float64 {
+		if q, exist := qValues[coding]; exist {
+			return q
+		}
+		if q, exist := qValues["*"]; exist {
+			return q
+		}
+		return defaultQ
+	}
+
+	selected, selectedQ := "", 0.0
+	for _, encoding := range encodings {
+		if q := getQ(encoding, 0); q > selectedQ {
+			selected, selectedQ = encoding, q
+		}
+	}
+	// identity는 언급이 없으면 받을 수는 있지만 가장 덜 선호하는 것으로 봄
+	if getQ("identity", 0.001) > selectedQ {
+		return ""
+	}
+	return selected
+}
+
+// 미리 압축해둔 본문으로 응답. 없으면 (압축하기 전에 저장된 캐시 등) 보내면서 압축
+func responseEncoded(cacheItem cache.CacheItem, body io.Reader, encoding string, state *requestState, w http.ResponseWriter) {
+	w.Header().Set("Content-Encoding", encoding)
+
+	// Open에서 함께 읽은 경우 다시 조회하지 않음
+	if encodedBody, exist := cacheItem.EncodedBodies[encoding]; exist {
+		w.Header().Set("Content-Length", strconv.Itoa(len(encodedBody)))
+		w.Write(encodedBody)
+		return
+	}
+	if encodedBody, exist := myCache.OpenEncoded(state.hashKey, state.sha256, encoding); exist {
+		defer encodedBody.Close()
+		if size, ok := cacheItem.EncodedSizes[encoding]; ok {
+			w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+		}
+		io.Copy(w, encodedBody)
+		return
+	}
+
+	encoder := newEncoder(encoding, w)
+	io.Copy(encoder, body)
+	encoder.Close()
+}
+
+// Vary에 name이 없으면 추가
+func addVary(header http.Header, name string) {
+	for _, value := range header.Values("Vary") {
+		for _, field := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(field), name) {
+				return
+			}
+		}
+	}
+	header.Add("Vary", name)
+}
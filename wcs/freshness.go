@@ -0,0 +1,68 @@
+package wcs
+
+import (
+	"jnlee/cache"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// 응답을 받은 시각 기준으로 캐시가 만료되는 시각 (RFC 9111 4.2)
+func GetExpirationTime(header http.Header, hostConfig HostConfig, responseTime time.Time) time.Time {
+	lifetime := GetFreshnessLifetime(header, hostConfig, responseTime)
+	return responseTime.Add(lifetime - GetInitialAge(header, responseTime))
+}
+
+// s-maxage > max-age > Expires - Date > Last-Modified 기반 추정값 > Host의 DefaultTTL 순서로 결정 (RFC 9111 4.2.1)
+func GetFreshnessLifetime(header http.Header, hostConfig HostConfig, responseTime time.Time) time.Duration {
+	cc := GetCacheControl(header)
+	if seconds, ok := cc.Seconds("s-maxage"); ok {
+		return time.Duration(seconds) * time.Second
+	}
+	if seconds, ok := cc.Seconds("max-age"); ok {
+		return time.Duration(seconds) * time.Second
+	}
+
+	date := getDateHeader(header, responseTime)
+	if expiresValue := header.Get("Expires"); expiresValue != "" {
+		// 잘못된 형식의 Expires는 이미 만료된 것으로 봄
+		expires, err := http.ParseTime(expiresValue)
+		if err != nil || expires.Before(date) {
+			return 0
+		}
+		return expires.Sub(date)
+	}
+
+	// Heuristic freshness (RFC 9111 4.2.2). HeuristicFreshnessPercent가 0이면 사용하지 않음
+	percent := GetConfig().HeuristicFreshPercent
+	if lastModified, err := http.ParseTime(header.Get("Last-Modified")); err == nil && lastModified.Before(date) && percent > 0 {
+		return date.Sub(lastModified) * time.Duration(percent) / 100
+	}
+
+	return time.Duration(hostConfig.DefaultTTL) * time.Second
+}
+
+// Origin 또는 상위 캐시에서 이미 지난 시간 (RFC 9111 4.2.3의 corrected_initial_age)
+func GetInitialAge(header http.Header, responseTime time.Time) time.Duration {
+	var apparentAge, ageValue time.Duration
+	if date, err := http.ParseTime(header.Get("Date")); err == nil && responseTime.After(date) {
+		apparentAge = responseTime.Sub(date)
+	}
+	if age, err := strconv.Atoi(header.Get("Age")); err == nil && age > 0 {
+		ageValue = time.Duration(age) * time.Second
+	}
+	return max(apparentAge, ageValue)
+}
+
+// Client에 보낼 Age 헤더 값 (초)
+func getAge(ci cache.CacheItem) int {
+	return int((ci.InitialAge + time.Since(ci.CachedTime)).Seconds())
+}
+
+func getDateHeader(header http.Header, responseTime time.Time) time.Time {
+	date, err := http.ParseTime(header.Get("Date"))
+	if err != nil {
+		return responseTime
+	}
+	return date
+}
@@ -0,0 +1,66 @@
+package wcs
+
+import (
+	"jnlee/cache"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// RFC 9110 7.6.1. 연결마다 다른 헤더이므로 저장된 응답에서 다시 보내지 않음
+	hopByHopHeaders = []string{"Connection", "Keep-Alive", "Proxy-Connection", "Proxy-Authenticate", "Proxy-Authorization", "Te", "Trailer", "Transfer-Encoding", "Upgrade"}
+	// 캐시 응답을 보낼 때 새로 계산하는 헤더
+	recomputedHeaders = []string{"Age", "Content-Length", "Date", "Content-Encoding", "Content-Range", "Cache-Status", "X-Cache", "X-Cache-Key"}
+	// 캐시 안에서만 쓰는 헤더. 저장은 하지만 Client에는 보내지 않음
+	internalHeaders = []string{"Surrogate-Key"}
+)
+
+// 저장된 헤더 중 캐시 응답에 다시 보낼 end-to-end 헤더.
+// allowList가 있으면 그 헤더만 보내고, denyList의 헤더는 보내지 않음
+func GetReplayHeader(stored http.Header, allowList []string, denyList []string) http.Header {
+	excluded := map[string]bool{}
+	for _, key := range slices.Concat(hopByHopHeaders, recomputedHeaders, internalHeaders, denyList) {
+		excluded[http.CanonicalHeaderKey(key)] = true
+	}
+	// Connection에 적힌 헤더도 hop-by-hop
+	for _, value := range stored.Values("Connection") {
+		for _, key := range strings.Split(value, ",") {
+			excluded[http.CanonicalHeaderKey(strings.TrimSpace(key))] = true
+		}
+	}
+	allowed := func(key string) bool {
+		return len(allowList) == 0 || slices.ContainsFunc(allowList, func(allow string) bool {
+			return strings.EqualFold(allow, key)
+		})
+	}
+
+	header := http.Header{}
+	for key, values := range stored {
+		key = http.CanonicalHeaderKey(key)
+		if excluded[key] || !allowed(key) {
+			continue
+		}
+		header[key] = slices.Clone(values)
+	}
+	return header
+}
+
+// Origin 응답을 Client에 보내기 전에 지움. 저장할 헤더는 미리 복사해 둠
+func removeInternalHeaders(header http.Header) {
+	for _, key := range internalHeaders {
+		header.Del(key)
+	}
+}
+
+// 캐시 응답의 헤더. 저장된 헤더에 Age, Date를 다시 계산해서 붙임
+func setHitHeader(dst http.Header, ci cache.CacheItem) {
+	config := GetConfig()
+	for key, values := range GetReplayHeader(ci.Header, config.HitHeaderAllowList, config.HitHeaderDenyList) {
+		dst[key] = values
+	}
+	dst.Set("Age", strconv.Itoa(getAge(ci)))
+	dst.Set("Date", time.Now().UTC().Format(http.TimeFormat))
+}
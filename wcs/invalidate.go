@@ -0,0 +1,45 @@
+package wcs
+
+import (
+	"net/http"
+	"net/url"
+)
+
+// RFC 9110 9.2.1. 이 method 외의 요청은 Origin의 데이터를 바꿀 수 있음
+func isSafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	}
+	return false
+}
+
+// RFC 9111 4.4. 안전하지 않은 요청이 성공하면 요청 URI와 같은 Host의 Location, Content-Location URI의 캐시를 삭제
+func invalidateByUnsafeMethod(resp *http.Response, state *requestState) {
+	if isSafeMethod(resp.Request.Method) || resp.StatusCode < 200 || resp.StatusCode >= 400 {
+		return
+	}
+	requestURL, err := url.Parse(state.url)
+	if err != nil {
+		return
+	}
+
+	targets := []*url.URL{requestURL}
+	for _, key := range []string{"Location", "Content-Location"} {
+		value := resp.Header.Get(key)
+		if value == "" {
+			continue
+		}
+		target, err := requestURL.Parse(value)
+		if err == nil && target.Host == requestURL.Host {
+			targets = append(targets, target)
+		}
+	}
+
+	for _, target := range targets {
+		// 저장할 때와 같은 key가 되도록 GET 요청으로 만듦 (HEAD도 같은 key를 사용)
+		req := &http.Request{Method: http.MethodGet, Host: state.host, URL: &url.URL{Path: target.Path, RawQuery: target.RawQuery}}
+		matched := purgeURI(GetURI(req), target.String(), false, "Invalidated")
+		invalidatedTotal.With(state.host).Add(float64(matched))
+	}
+}
@@ -0,0 +1,282 @@
+package wcs
+
+import (
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// LogLevel. 설정한 level보다 낮은 로그는 남기지 않음
+const (
+	LOG_LEVEL_DEBUG string = "debug"
+	LOG_LEVEL_INFO  string = "info"
+	LOG_LEVEL_WARN  string = "warn"
+	LOG_LEVEL_ERROR string = "error"
+)
+
+var logLevels = map[string]int{LOG_LEVEL_DEBUG: 0, LOG_LEVEL_INFO: 1, LOG_LEVEL_WARN: 2, LOG_LEVEL_ERROR: 3}
+
+type MyLogger struct {
+	logger *log.Logger
+}
+
+func validateLogConfig(config ConfigStruct) error {
+	if _, ok := logLevels[config.LogLevel]; !ok && config.LogLevel != "" {
+		return fmt.Errorf("unknown LogLevel %q", config.LogLevel)
+	}
+	if config.LogMaxSize < 0 || config.LogRotateInterval < 0 || config.LogMaxBackups < 0 {
+		return fmt.Errorf("LogMaxSize, LogRotateInterval, LogMaxBackups must not be negative")
+	}
+	return nil
+}
+
+// Config에 없으면 info
+func getLogLevel() string {
+	if level := GetConfig().LogLevel; level != "" {
+		return level
+	}
+	return LOG_LEVEL_INFO
+}
+
+func (mLogger *MyLogger) logf(level string, format string, args ...any) {
+	if logLevels[level] < logLevels[getLogLevel()] {
+		return
+	}
+	mLogger.logger.Printf("["+strings.ToUpper(level)+"] "+format, args...)
+}
+
+func (mLogger *MyLogger) Debugf(format string, args ...any) {
+	mLogger.logf(LOG_LEVEL_DEBUG, format, args...)
+}
+
+func (mLogger *MyLogger) Infof(format string, args ...any) {
+	mLogger.logf(LOG_LEVEL_INFO, format, args...)
+}
+
+func (mLogger *MyLogger) Warnf(format string, args ...any) {
+	mLogger.logf(LOG_LEVEL_WARN, format, args...)
+}
+
+func (mLogger *MyLogger) Errorf(format string, args ...any) {
+	mLogger.logf(LOG_LEVEL_ERROR, format, args...)
+}
+
+func (mLogger *MyLogger) LogElapsedTime(url string, elapsedTime time.Duration) {
+	mLogger.Infof("ResponseTime : %s, %s\n", url, elapsedTime)
+}
+
+func (mLogger *MyLogger) LogCacheNum(cachedFile int, sendCache int) {
+	mLogger.Debugf("Cached File Number = %d, Send cache file number = %d\n", cachedFile, sendCache)
+}
+
+func generateLogger(w io.Writer) *MyLogger {
+	logger := &MyLogger{log.New(w, "\n", log.Ldate|log.Ltime)}
+	return logger
+}
+
+// 로그 파일. LogMaxSize를 넘거나 LogRotateInterval이 지나면 파일 이름 뒤에 시각을 붙여 보관하고 새 파일에 씀
+type rotateWriter struct {
+	mu       sync.Mutex
+	path     string
+	file     *os.File
+	size     int64
+	openedAt time.Time
+	closed   bool // Close 이후에는 보관을 시작하지 않음
+
+	// 보관 파일 압축, 정리는 쓰기를 막지 않도록 lock 밖에서 하나씩 함
+	archiveMu sync.Mutex
+	archiving sync.WaitGroup
+}
+
+func openLoggerFile(path string) *rotateWriter {
+	rw := &rotateWriter{path: path}
+	err := rw.open()
+	if err != nil {
+		panic(err)
+	}
+	return rw
+}
+
+// 새 파일을 연 뒤에 이전 파일을 닫음. 열지 못하면 이전 파일에 계속 씀
+func (rw *rotateWriter) open() error {
+	file, err := os.OpenFile(rw.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	if rw.file != nil {
+		rw.file.Close()
+	}
+	rw.file, rw.size, rw.openedAt = file, info.Size(), time.Now()
+	return nil
+}
+
+func (rw *rotateWriter) Write(p []byte) (int, error) {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+
+	if !rw.closed && rw.shouldRotate(len(p)) {
+		// 보관에 실패해도 로그는 계속 씀
+		rotatedPath, err := rw.rotate()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Log rotate error : %s (%v)\n", rw.path, err)
+		} else {
+			rw.archiving.Add(1)
+			go rw.archive(rotatedPath)
+		}
+	}
+	n, err := rw.file.Write(p)
+	rw.size += int64(n)
+	return n, err
+}
+
+func (rw *rotateWriter) shouldRotate(writeSize int) bool {
+	config := GetConfig()
+	if config.LogMaxSize > 0 && rw.size > 0 && rw.size+int64(writeSize) > config.LogMaxSize*1024*1024 {
+		return true
+	}
+	interval := time.Second * time.Duration(config.LogRotateInterval)
+	return interval > 0 && time.Since(rw.openedAt) >= interval
+}
+
+// 쓰던 파일을 보관 이름으로 옮기고 같은 경로에 새 파일을 엶. 새 파일을 열지 못하면 되돌림
+func (rw *rotateWriter) rotate() (string, error) {
+	rotatedPath := getRotatedPath(rw.path, time.Now())
+	if err := os.Rename(rw.path, rotatedPath); err != nil {
+		return "", err
+	}
+	if err := rw.open(); err != nil {
+		os.Rename(rotatedPath, rw.path)
+		return "", err
+	}
+	return rotatedPath, nil
+}
+
+func (rw *rotateWriter) archive(rotatedPath string) {
+	defer rw.archiving.Done()
+	rw.archiveMu.Lock()
+	defer rw.archiveMu.Unlock()
+
+	config := GetConfig()
+	if config.LogCompress {
+		if err := compressFile(rotatedPath); err != nil {
+			fmt.Fprintf(os.Stderr, "Log compress error : %s (%v)\n", rotatedPath, err)
+		}
+	}
+	if err := removeOldLogs(rw.path, config.LogMaxBackups); err != nil {
+		fmt.Fprintf(os.Stderr, "Log remove error : %s (%v)\n", rw.path, err)
+	}
+}
+
+// path.20060102-150405.000. 같은 이름의 보관 파일이 있으면 뒤에 번호를 붙임
+func getRotatedPath(path string, now time.Time) string {
+	base := path + "." + now.Format("20060102-150405.000")
+	rotatedPath := base
+	for i := 1; ; i++ {
+		_, err := os.Stat(rotatedPath)
+		_, gzErr := os.Stat(rotatedPath + ".gz")
+		if os.IsNotExist(err) && os.IsNotExist(gzErr) {
+			return rotatedPath
+		}
+		rotatedPath = fmt.Sprintf("%s-%d", base, i)
+	}
+}
+
+// logrotate 같은 외부 도구가 파일을 옮긴 뒤 같은 경로에 새 파일을 만듦
+func (rw *rotateWriter) Reopen() error {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+	return rw.open()
+}
+
+// 진행 중인 압축, 정리가 끝난 뒤에 닫음.
+// 기다리는 동안 Write가 막히지 않도록 lock을 놓고 기다림
+func (rw *rotateWriter) Close() error {
+	rw.mu.Lock()
+	rw.closed = true
+	rw.mu.Unlock()
+	rw.archiving.Wait()
+
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+	rw.file.Sync()
+	return rw.file.Close()
+}
+
+// path를 gzip으로 압축한 path.gz를 만들고 원본은 삭제
+func compressFile(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.Create(path + ".gz")
+	if err != nil {
+		return err
+	}
+	gzWriter := gzip.NewWriter(dst)
+	_, err = io.Copy(gzWriter, src)
+	if closeErr := gzWriter.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path + ".gz")
+		return err
+	}
+	return os.Remove(path)
+}
+
+// 보관된 로그 파일 중 최근 maxBackups개만 남김. 0이면 모두 남김
+func removeOldLogs(path string, maxBackups int) error {
+	if maxBackups <= 0 {
+		return nil
+	}
+	backups, err := filepath.Glob(path + ".*")
+	if err != nil {
+		return err
+	}
+	// 이름에 붙은 시각 순서
+	sort.Strings(backups)
+	for len(backups) > maxBackups {
+		os.Remove(backups[0])
+		backups = backups[1:]
+	}
+	return nil
+}
+
+// SIGUSR1을 받으면 로그 파일을 다시 엶
+func watchReopenSignal(ctx context.Context, writers ...*rotateWriter) {
+	signalC := make(chan os.Signal, 1)
+	signal.Notify(signalC, syscall.SIGUSR1)
+	defer signal.Stop(signalC)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-signalC:
+		}
+		for _, rw := range writers {
+			if err := rw.Reopen(); err != nil {
+				fmt.Fprintf(os.Stderr, "Log reopen error : %s (%v)\n", rw.path, err)
+			}
+		}
+		myLogger.Infof("Log files reopened (SIGUSR1)\n")
+	}
+}
@@ -0,0 +1,102 @@
+package wcs
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// 현재 Config에 로그 설정만 바꿈. 테스트가 끝나면 Config를 되돌림
+func setTestLogConfig(t *testing.T, logConfig ConfigStruct) {
+	oldConfig := *GetConfig()
+	t.Cleanup(func() { SetConfig(oldConfig) })
+
+	config := oldConfig
+	config.LogLevel, config.LogMaxSize, config.LogRotateInterval = logConfig.LogLevel, logConfig.LogMaxSize, logConfig.LogRotateInterval
+	config.LogMaxBackups, config.LogCompress = logConfig.LogMaxBackups, logConfig.LogCompress
+	if err := SetConfig(config); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLogLevel(t *testing.T) {
+	setTestLogConfig(t, ConfigStruct{LogLevel: LOG_LEVEL_WARN})
+
+	var sb strings.Builder
+	logger := generateLogger(&sb)
+	logger.Debugf("debug\n")
+	logger.Infof("info\n")
+	logger.Warnf("warn\n")
+	logger.Errorf("error\n")
+
+	val := sb.String()
+	if strings.Contains(val, "debug") || strings.Contains(val, "info") || !strings.Contains(val, "[WARN] warn") || !strings.Contains(val, "[ERROR] error") {
+		t.Errorf("log %q", val)
+	}
+
+	if validateLogConfig(ConfigStruct{LogLevel: "trace"}) == nil || validateLogConfig(ConfigStruct{LogMaxBackups: -1}) == nil {
+		t.Error("Invalid log config accepted")
+	}
+}
+
+func TestRotateWriter(t *testing.T) {
+	setTestLogConfig(t, ConfigStruct{LogMaxSize: 1, LogMaxBackups: 2, LogCompress: true})
+
+	path := filepath.Join(t.TempDir(), "log_file.txt")
+	rw := openLoggerFile(path)
+
+	// 1MB를 넘는 쓰기마다 보관. 3번 보관해도 2개만 남음
+	line := strings.Repeat("a", 600*1024)
+	for range 4 {
+		rw.Write([]byte(line))
+	}
+	rw.archiving.Wait()
+	backups, _ := filepath.Glob(path + ".*")
+	if len(backups) != 2 {
+		t.Fatalf("backups %v", backups)
+	}
+	for _, backup := range backups {
+		if !strings.HasSuffix(backup, ".gz") {
+			t.Errorf("%s not compressed", backup)
+			continue
+		}
+		f, _ := os.Open(backup)
+		gzReader, err := gzip.NewReader(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(gzReader)
+		f.Close()
+		if len(body) != len(line) {
+			t.Errorf("%s : %d bytes", backup, len(body))
+		}
+	}
+
+	// logrotate가 파일을 옮긴 뒤 Reopen하면 같은 경로에 새로 씀.
+	// 새 파일을 열지 못하면 이전 파일에 계속 씀
+	os.Rename(path, path+".moved")
+	os.Mkdir(path, 0700)
+	if err := rw.Reopen(); err == nil {
+		t.Error("reopened directory")
+	}
+	if _, err := rw.Write([]byte("old")); err != nil {
+		t.Errorf("write after failed reopen : %v", err)
+	}
+	os.Remove(path)
+	rw.Reopen()
+	rw.Write([]byte("new"))
+	if body, _ := os.ReadFile(path); string(body) != "new" {
+		t.Errorf("reopened file %q", body)
+	}
+
+	// Close 이후의 Write는 보관하지 않음
+	rw.Close()
+	rw.Write([]byte(line + line))
+	rw.archiving.Wait()
+	if after, _ := filepath.Glob(path + ".*"); len(after) != len(backups)+1 {
+		t.Errorf("rotated after Close : %v", after)
+	}
+}
@@ -0,0 +1,129 @@
+package wcs
+
+import (
+	"jnlee/metrics"
+	"net/http"
+	"time"
+)
+
+// 요청 처리 결과 (wcs_requests_total의 result)
+const (
+	RESULT_HIT         string = "hit"         // 만료되지 않은 캐시로 응답
+	RESULT_STALE       string = "stale"       // stale-while-revalidate, stale-if-error로 만료된 캐시로 응답
+	RESULT_REVALIDATED string = "revalidated" // Origin이 304로 응답해 저장된 캐시로 응답
+	RESULT_MISS        string = "miss"        // Origin의 응답을 보냄
+	RESULT_BYPASS      string = "bypass"      // 캐시를 사용하지 않는 method
+)
+
+// 캐시하지 않은 이유 (wcs_not_cached_total의 reason)
+const (
+	REASON_FILE_SIZE       string = "file_size"
+	REASON_CACHE_EXCEPTION string = "cache_exception"
+	REASON_STATUS          string = "status"
+	REASON_METHOD          string = "method"
+	REASON_CACHE_CONTROL   string = "cache_control"
+	REASON_CONTENT_TYPE    string = "content_type"
+	REASON_VARY            string = "vary"
+	REASON_ENCODING        string = "content_encoding"
+	REASON_AUTHORIZATION   string = "authorization"
+)
+
+var (
+	registry = metrics.NewRegistry()
+
+	requestsTotal      = registry.NewCounterVec("wcs_requests_total", "Client requests by host and result (hit, stale, revalidated, miss, bypass).", "host", "result")
+	notCachedTotal     = registry.NewCounterVec("wcs_not_cached_total", "Origin responses not stored, by reason.", "host", "reason")
+	cachedTotal        = registry.NewCounterVec("wcs_cached_total", "Origin responses stored in the cache.", "host")
+	notModifiedTotal   = registry.NewCounterVec("wcs_not_modified_total", "304 Not Modified responses sent from the cache.", "host")
+	invalidatedTotal   = registry.NewCounterVec("wcs_invalidated_total", "Cache entries invalidated by unsafe requests.", "host")
+	responseBytesTotal = registry.NewCounterVec("wcs_response_bytes_total", "Response body bytes sent to clients, by source (cache, origin).", "host", "source")
+	originDuration     = registry.NewHistogramVec("wcs_origin_request_duration_seconds", "Time until the origin response headers are received.",
+		[]float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}, "host")
+)
+
+func init() {
+	// 전체 목록을 읽어야 구할 수 있으면 scrape마다 읽지 않도록 보내지 않음
+	registry.NewOptionalGaugeFunc("wcs_cache_entries", "Cache entries in the store.", func() (float64, bool) {
+		stats, ok := getCacheStats()
+		return float64(stats.Items), ok
+	})
+	registry.NewOptionalGaugeFunc("wcs_cache_bytes", "Body bytes of cache entries in the store.", func() (float64, bool) {
+		stats, ok := getCacheStats()
+		return float64(stats.UsedBytes), ok
+	})
+	registry.NewCounterFunc("wcs_cache_evictions_total", "Cache entries evicted by EvictionPolicy.", func() float64 {
+		stats, _ := getCacheStats()
+		return float64(stats.Evictions)
+	})
+	registry.NewGaugeFunc("wcs_workerpool_queue_depth", "Tasks waiting for a free worker.", func() float64 {
+		if Workerpool == nil {
+			return 0
+		}
+		return float64(Workerpool.QueueDepth())
+	})
+}
+
+func increaseNotCached(state *requestState, reason string) {
+	state.notCachedReason = reason
+	notCachedTotal.With(state.host, reason).Inc()
+}
+
+// 응답을 마친 요청을 result별로 셈
+func recordRequest(state *requestState, written int64) {
+	requestsTotal.With(state.host, state.result).Inc()
+	source := "origin"
+	switch state.result {
+	case RESULT_HIT, RESULT_STALE, RESULT_REVALIDATED:
+		source = "cache"
+	}
+	responseBytesTotal.With(state.host, source).Add(float64(written))
+}
+
+// Status Page의 Hit 수. stale 응답도 캐시로 응답한 것이므로 포함
+func isHitResult(result string) bool {
+	return result == RESULT_HIT || result == RESULT_STALE
+}
+
+// Client에 보낸 status와 본문 크기를 기록
+type countingResponseWriter struct {
+	http.ResponseWriter
+	status  int
+	written int64
+}
+
+func (cw *countingResponseWriter) WriteHeader(statusCode int) {
+	// 103 Early Hints 같은 1xx 응답 뒤에 실제 응답이 옴
+	if cw.status == 0 && statusCode >= 200 {
+		cw.status = statusCode
+	}
+	cw.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (cw *countingResponseWriter) Write(p []byte) (int, error) {
+	if cw.status == 0 {
+		cw.status = http.StatusOK
+	}
+	n, err := cw.ResponseWriter.Write(p)
+	cw.written += int64(n)
+	return n, err
+}
+
+// ReverseProxy가 Flush할 수 있도록 원래 ResponseWriter를 돌려줌
+func (cw *countingResponseWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// Origin 응답 헤더를 받을 때까지의 시간을 기록
+type originTransport struct {
+	http.RoundTripper
+}
+
+func (ot originTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	startTime := time.Now()
+	resp, err := ot.RoundTripper.RoundTrip(req)
+	if state := getRequestState(req); state != nil {
+		state.originTime = time.Since(startTime)
+		originDuration.With(state.host).Observe(state.originTime.Seconds())
+	}
+	return resp, err
+}
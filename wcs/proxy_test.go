@@ -0,0 +1,860 @@
+package wcs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"jnlee/cache"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// origin을 Origin으로 하는 GLOBAL_HOST(config.Hosts[0]의 설정 사용)와 메모리 캐시로 proxy를 준비. 테스트가 끝나면 Config와 캐시를 되돌림
+func newTestProxy(t *testing.T, config ConfigStruct, origin http.HandlerFunc) http.Handler {
+	server := httptest.NewServer(origin)
+	t.Cleanup(server.Close)
+
+	if Workerpool == nil {
+		InitWorkerpool()
+	}
+	// Workerpool에 남은 저장, 재검증 작업이 끝난 뒤에 되돌림
+	oldConfig, oldLogger, oldCache := *GetConfig(), myLogger, myCache
+	t.Cleanup(func() {
+		Workerpool.Wait()
+		SetConfig(oldConfig)
+		myLogger, myCache = oldLogger, oldCache
+	})
+
+	if config.MaxFileSize == 0 {
+		config.MaxFileSize = 100000
+	}
+	if config.CleanupFrequency == 0 {
+		config.CleanupFrequency = 60
+	}
+	config.StoreType = STORE_TYPE_FILE
+	hostConfig := HostConfig{}
+	if len(config.Hosts) > 0 {
+		hostConfig = config.Hosts[0]
+	}
+	hostConfig.Host, hostConfig.Origin = GLOBAL_HOST, server.URL
+	config.Hosts = []HostConfig{hostConfig}
+	if err := SetConfig(config); err != nil {
+		t.Fatal(err)
+	}
+
+	myCache = &cache.MemoryCache{}
+	myCache.Init()
+	myLogger = &MyLogger{log.New(io.Discard, "", 0)}
+	initVaryIndex()
+	return &proxyHandler{}
+}
+
+func serveTestRequest(handler http.Handler, method string, url string) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(method, url, nil))
+	return recorder
+}
+
+func TestHitHeader(t *testing.T) {
+	config := ConfigStruct{HitHeaderDenyList: []string{"Set-Cookie"}}
+	handler := newTestProxy(t, config, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("Content-Type", "text/html")
+		w.Header().Set("Content-Language", "ko")
+		w.Header().Set("Last-Modified", "Mon, 20 Nov 2023 10:00:00 GMT")
+		w.Header().Set("Etag", `"abc"`)
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("X-Origin", "origin")
+		w.Header().Set("Set-Cookie", "session=1")
+		io.WriteString(w, "hello")
+	})
+
+	url := "http://" + GLOBAL_HOST + "/hit-header"
+	miss := serveTestRequest(handler, http.MethodGet, url)
+	hit := serveTestRequest(handler, http.MethodGet, url)
+	if hit.Header().Get("jnlee") != "HIT" || hit.Body.String() != "hello" {
+		t.Fatalf("second request must be a hit : %v", hit.Header())
+	}
+
+	for key, values := range miss.Header() {
+		switch key {
+		case "Set-Cookie":
+			if hit.Header().Get(key) != "" {
+				t.Errorf("%s must not be replayed", key)
+			}
+		case "Date", "Cache-Status":
+			if hit.Header().Get(key) == "" {
+				t.Errorf("%s must be set", key)
+			}
+		default:
+			if !slices.Equal(hit.Header().Values(key), values) {
+				t.Errorf("%s : miss %v, hit %v", key, values, hit.Header().Values(key))
+			}
+		}
+	}
+	if hit.Header().Get("Age") == "" {
+		t.Error("Age must be set")
+	}
+}
+
+func TestHeadRequest(t *testing.T) {
+	requests := []string{}
+	origin := func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method)
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("Content-Type", "text/html")
+		io.WriteString(w, "hello")
+	}
+
+	// HEAD miss는 저장하지 않고, 이후 GET이 저장한 캐시로 HEAD에 응답
+	handler := newTestProxy(t, ConfigStruct{}, origin)
+	url := "http://" + GLOBAL_HOST + "/head"
+	serveTestRequest(handler, http.MethodHead, url)
+	serveTestRequest(handler, http.MethodGet, url)
+	head := serveTestRequest(handler, http.MethodHead, url)
+	if !slices.Equal(requests, []string{http.MethodHead, http.MethodGet}) {
+		t.Errorf("origin requests %v", requests)
+	}
+	if head.Header().Get("jnlee") != "HIT" || head.Header().Get("Content-Length") != "5" || head.Body.Len() != 0 {
+		t.Errorf("HEAD hit : %v, %q", head.Header(), head.Body.String())
+	}
+
+	// HEAD miss를 GET으로 바꿔서 캐시를 채움
+	requests = nil
+	handler = newTestProxy(t, ConfigStruct{HeadUpgradeEnabled: true}, origin)
+	head = serveTestRequest(handler, http.MethodHead, url)
+	get := serveTestRequest(handler, http.MethodGet, url)
+	if !slices.Equal(requests, []string{http.MethodGet}) {
+		t.Errorf("origin requests %v", requests)
+	}
+	if head.Body.Len() != 0 || get.Header().Get("jnlee") != "HIT" || get.Body.String() != "hello" {
+		t.Errorf("HEAD upgrade : %q, %v, %q", head.Body.String(), get.Header(), get.Body.String())
+	}
+}
+
+func TestUnsafeMethodInvalidation(t *testing.T) {
+	origin := func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			w.Header().Set("Location", "/created")
+			w.Header().Set("Content-Location", "http://other.host/ignored")
+			w.WriteHeader(http.StatusCreated)
+			return
+		}
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("Content-Type", "text/html")
+		w.Header().Set("Vary", "Accept-Language")
+		io.WriteString(w, r.URL.Path)
+	}
+	handler := newTestProxy(t, ConfigStruct{}, origin)
+	invalidated := invalidatedTotal.With(GLOBAL_HOST).Value()
+
+	base := "http://" + GLOBAL_HOST
+	for _, url := range []string{base + "/items", base + "/created", base + "/other"} {
+		serveTestRequest(handler, http.MethodGet, url)
+	}
+	serveTestRequest(handler, http.MethodPost, base+"/items")
+
+	for url, cached := range map[string]bool{base + "/items": false, base + "/created": false, base + "/other": true} {
+		head := serveTestRequest(handler, http.MethodHead, url)
+		if (head.Header().Get("jnlee") == "HIT") != cached {
+			t.Errorf("%s : cached must be %v", url, cached)
+		}
+	}
+	if count := invalidatedTotal.With(GLOBAL_HOST).Value() - invalidated; count != 2 {
+		t.Errorf("invalidated %v, want 2", count)
+	}
+}
+
+func TestPurge(t *testing.T) {
+	conditional := 0
+	origin := func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") != "" {
+			conditional += 1
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("Content-Type", "text/html")
+		w.Header().Set("Etag", `"v1"`)
+		if strings.HasPrefix(r.URL.Path, "/products/") {
+			w.Header().Set("Surrogate-Key", "product "+strings.TrimPrefix(r.URL.Path, "/products/"))
+		}
+		io.WriteString(w, r.URL.Path)
+	}
+	handler := newTestProxy(t, ConfigStruct{}, origin)
+
+	base := "http://" + GLOBAL_HOST
+	urls := []string{base + "/products/1", base + "/products/2", base + "/images/1", base + "/images/2?size=big"}
+	isCached := func(url string) bool {
+		return serveTestRequest(handler, http.MethodHead, url).Header().Get("jnlee") == "HIT"
+	}
+	purge := func(body string) PurgeResult {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "http://"+CUSTOM_HOST+"/purge", strings.NewReader(body)))
+		result := PurgeResult{}
+		json.NewDecoder(recorder.Body).Decode(&result)
+		return result
+	}
+	// Surrogate-Key는 저장해서 tag purge에만 쓰고 Client에는 보내지 않음
+	for _, url := range append(urls, urls[0]) {
+		if res := serveTestRequest(handler, http.MethodGet, url); res.Header().Get("Surrogate-Key") != "" {
+			t.Errorf("Surrogate-Key sent : %v", res.Header())
+		}
+		Workerpool.Wait()
+	}
+
+	dummy := []struct {
+		body    string
+		matched int
+		purged  []string
+	}{
+		{`{"url": "` + urls[3] + `"}`, 1, urls[3:]},
+		{`{"url": "` + urls[3] + `"}`, 0, urls[3:]},
+		{`{"tag": "2"}`, 1, urls[1:2]},
+		{`{"host": "` + GLOBAL_HOST + `", "prefix": "/images/"}`, 1, urls[2:3]},
+		{`{"pattern": "products"}`, 1, urls[0:1]},
+	}
+	for _, d := range dummy {
+		if result := purge(d.body); result.Matched != d.matched || result.Error != "" {
+			t.Errorf("%s : %+v", d.body, result)
+		}
+		for _, url := range d.purged {
+			if isCached(url) {
+				t.Errorf("%s : %s must be purged", d.body, url)
+			}
+		}
+	}
+	if result := purge(`{}`); result.Error == "" {
+		t.Error("empty purge request must fail")
+	}
+
+	// soft purge는 캐시를 남겨두고 다음 요청에서 재검증
+	serveTestRequest(handler, http.MethodGet, urls[0])
+	if result := purge(`{"url": "` + urls[0] + `", "soft": true}`); result.Matched != 1 || !result.Soft {
+		t.Errorf("soft purge : %+v", result)
+	}
+	if isCached(urls[0]) || conditional != 1 {
+		t.Errorf("soft purged entry must be revalidated (conditional requests %d)", conditional)
+	}
+}
+
+func TestAdminAuth(t *testing.T) {
+	config := ConfigStruct{
+		AdminTokens:     []string{"secret-token"},
+		AdminUsers:      map[string]string{"admin": "password"},
+		AdminAllowedIPs: []string{"192.0.2.1", "10.0.0.0/8"},
+	}
+	handler := newTestProxy(t, config, func(w http.ResponseWriter, r *http.Request) {})
+
+	dummy := []struct {
+		remoteAddr    string
+		authorization string
+		status        int
+	}{
+		{"10.1.2.3:1234", "Bearer secret-token", http.StatusMethodNotAllowed},
+		{"192.0.2.1:1234", "Basic YWRtaW46cGFzc3dvcmQ=", http.StatusMethodNotAllowed}, // admin:password
+		{"10.1.2.3:1234", "Basic YWRtaW46d3Jvbmc=", http.StatusUnauthorized},          // admin:wrong
+		{"10.1.2.3:1234", "Bearer wrong-token", http.StatusUnauthorized},
+		{"10.1.2.3:1234", "", http.StatusUnauthorized},
+		{"192.0.2.2:1234", "Bearer secret-token", http.StatusForbidden},
+	}
+	for _, d := range dummy {
+		req := httptest.NewRequest(http.MethodGet, "http://"+CUSTOM_HOST+"/purge", nil)
+		req.RemoteAddr = d.remoteAddr
+		if d.authorization != "" {
+			req.Header.Set("Authorization", d.authorization)
+		}
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+		if recorder.Code != d.status {
+			t.Errorf("%s, %q : status %d, want %d", d.remoteAddr, d.authorization, recorder.Code, d.status)
+		}
+		if recorder.Code == http.StatusUnauthorized && len(recorder.Header().Values("WWW-Authenticate")) != 2 {
+			t.Errorf("WWW-Authenticate %v", recorder.Header().Values("WWW-Authenticate"))
+		}
+	}
+
+	config.AdminAllowedIPs = []string{"10.0.0.0/33"}
+	if err := SetConfig(config); err == nil {
+		t.Error("invalid CIDR must be rejected")
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	handler := newTestProxy(t, ConfigStruct{}, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("Content-Type", "text/html")
+		io.WriteString(w, "hello")
+	})
+	count := func(result string) float64 {
+		return requestsTotal.With(GLOBAL_HOST, result).Value()
+	}
+	hit, miss, bypass := count(RESULT_HIT), count(RESULT_MISS), count(RESULT_BYPASS)
+	cacheBytes := responseBytesTotal.With(GLOBAL_HOST, "cache").Value()
+	originBytes := responseBytesTotal.With(GLOBAL_HOST, "origin").Value()
+
+	url := "http://" + GLOBAL_HOST + "/metrics-test"
+	serveTestRequest(handler, http.MethodGet, url)
+	serveTestRequest(handler, http.MethodGet, url)
+	serveTestRequest(handler, http.MethodGet, url)
+	serveTestRequest(handler, http.MethodPut, url+"-other")
+
+	if count(RESULT_HIT)-hit != 2 || count(RESULT_MISS)-miss != 1 || count(RESULT_BYPASS)-bypass != 1 {
+		t.Errorf("hit %v, miss %v, bypass %v", count(RESULT_HIT)-hit, count(RESULT_MISS)-miss, count(RESULT_BYPASS)-bypass)
+	}
+	if responseBytesTotal.With(GLOBAL_HOST, "cache").Value()-cacheBytes != 10 || responseBytesTotal.With(GLOBAL_HOST, "origin").Value()-originBytes != 10 {
+		t.Errorf("response bytes : cache %v, origin %v", responseBytesTotal.With(GLOBAL_HOST, "cache").Value()-cacheBytes, responseBytesTotal.With(GLOBAL_HOST, "origin").Value()-originBytes)
+	}
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "http://"+CUSTOM_HOST+"/metrics", nil))
+	for _, line := range []string{
+		`wcs_requests_total{host="` + GLOBAL_HOST + `",result="hit"}`,
+		`wcs_origin_request_duration_seconds_count{host="` + GLOBAL_HOST + `"}`,
+		"wcs_workerpool_queue_depth 0",
+	} {
+		if !strings.Contains(recorder.Body.String(), line) {
+			t.Errorf("/metrics must contain %s", line)
+		}
+	}
+	// EvictionPolicy가 없으면 캐시 수와 크기를 보내지 않음
+	if strings.Contains(recorder.Body.String(), "wcs_cache_entries") {
+		t.Error("/metrics must not contain wcs_cache_entries without EvictionPolicy")
+	}
+}
+
+func TestAccessLog(t *testing.T) {
+	handler := newTestProxy(t, ConfigStruct{AccessLogFormat: ACCESS_LOG_JSON}, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("Content-Type", "text/html")
+		io.WriteString(w, "hello")
+	})
+	var buf strings.Builder
+	oldAccessLogger := accessLogger
+	accessLogger = log.New(&buf, "", 0)
+	t.Cleanup(func() { accessLogger = oldAccessLogger })
+
+	url := "http://" + GLOBAL_HOST + "/access-log?a=1"
+	serveTestRequest(handler, http.MethodGet, url)
+	serveTestRequest(handler, http.MethodGet, url)
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("access log lines %q", lines)
+	}
+	for i, cacheStatus := range []string{"MISS", "HIT"} {
+		entry := accessLogEntry{}
+		if err := json.Unmarshal([]byte(lines[i]), &entry); err != nil {
+			t.Fatal(err)
+		}
+		if entry.CacheStatus != cacheStatus || entry.Status != 200 || entry.Bytes != 5 || entry.URI != "/access-log?a=1" || entry.CacheKey == "" {
+			t.Errorf("%+v", entry)
+		}
+		if (entry.OriginTime > 0) != (cacheStatus == "MISS") {
+			t.Errorf("%s origin time %v", cacheStatus, entry.OriginTime)
+		}
+	}
+
+	entry := accessLogEntry{
+		Time: "2023-11-20T10:00:00+09:00", ClientIP: "192.0.2.1", Method: "GET", URI: "/a", Protocol: "HTTP/1.1",
+		Status: 304, CacheStatus: "HIT", TotalTime: 0.0015, CacheKey: "key", UserAgent: `curl "8"`,
+	}
+	dummy := map[string]string{
+		ACCESS_LOG_COMMON:   `192.0.2.1 - - [20/Nov/2023:10:00:00 +0900] "GET /a HTTP/1.1" 304 - HIT 0.000 0.002 key`,
+		ACCESS_LOG_COMBINED: `192.0.2.1 - - [20/Nov/2023:10:00:00 +0900] "GET /a HTTP/1.1" 304 - "-" "curl \"8\"" HIT 0.000 0.002 key`,
+	}
+	for format, ans := range dummy {
+		if val := formatAccessLog(entry, format); val != ans {
+			t.Errorf("%s :\n%s\nwant\n%s", format, val, ans)
+		}
+	}
+}
+
+func TestCacheStatus(t *testing.T) {
+	config := ConfigStruct{XCacheEnabled: true, Hosts: []HostConfig{{CacheDebugEnabled: true}}}
+	handler := newTestProxy(t, config, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		if strings.HasSuffix(r.URL.Path, "no-store") {
+			w.Header().Set("Cache-Control", "no-store")
+		}
+		w.Header().Set("Content-Type", "text/html")
+		w.Header().Set("Cache-Status", "upstream; hit")
+		io.WriteString(w, "hello")
+	})
+
+	url := "http://" + GLOBAL_HOST + "/cache-status"
+	dummy := []struct {
+		method      string
+		url         string
+		cacheStatus string
+		xCache      string
+	}{
+		{http.MethodGet, url, `^upstream; hit, jnlee; fwd=uri-miss; fwd-status=200; ttl=[1-6][0-9]; stored; key="[0-9a-f]{64}"$`, "MISS"},
+		{http.MethodGet, url, `^jnlee; hit; ttl=[1-6][0-9]; key="[0-9a-f]{64}"$`, "HIT"},
+		{http.MethodGet, url + "-no-store", `; fwd=uri-miss; fwd-status=200; key="[0-9a-f]{64}"; detail="cache_control"$`, "MISS"},
+		{http.MethodPost, url, `; fwd=bypass; fwd-status=200; key="[0-9a-f]{64}"; detail="method"$`, "BYPASS"},
+	}
+	for _, d := range dummy {
+		recorder := serveTestRequest(handler, d.method, d.url)
+		cacheStatus := strings.Join(recorder.Header().Values("Cache-Status"), ", ")
+		if !regexp.MustCompile(d.cacheStatus).MatchString(cacheStatus) || recorder.Header().Get("X-Cache") != d.xCache {
+			t.Errorf("%s %s : Cache-Status %q, X-Cache %q", d.method, d.url, cacheStatus, recorder.Header().Get("X-Cache"))
+		}
+	}
+
+	getReq := httptest.NewRequest(http.MethodGet, url, nil)
+	if key := serveTestRequest(handler, http.MethodGet, url).Header().Get("X-Cache-Key"); key != GetURI(getReq) {
+		t.Errorf("X-Cache-Key %q", key)
+	}
+
+	// 외부 Client에는 캐시 key를 보내지 않음
+	config = *GetConfig()
+	config.CacheDebugInternalOnly = true
+	config.AdminAllowedIPs = []string{"10.0.0.0/8"}
+	if err := SetConfig(config); err != nil {
+		t.Fatal(err)
+	}
+	hit := serveTestRequest(handler, http.MethodGet, url)
+	if strings.Contains(hit.Header().Get("Cache-Status"), "key=") || hit.Header().Get("X-Cache-Key") != "" {
+		t.Errorf("debug header sent to external client : %v", hit.Header())
+	}
+
+	// AdminAllowedIPs가 비어 있으면 아무에게도 보내지 않음
+	config.AdminAllowedIPs = nil
+	if err := SetConfig(config); err != nil {
+		t.Fatal(err)
+	}
+	hit = serveTestRequest(handler, http.MethodGet, url)
+	if strings.Contains(hit.Header().Get("Cache-Status"), "key=") || hit.Header().Get("X-Cache-Key") != "" {
+		t.Errorf("debug header sent without AdminAllowedIPs : %v", hit.Header())
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	startedC := make(chan struct{})
+	handler := newTestProxy(t, ConfigStruct{ShutdownTimeout: 5}, func(w http.ResponseWriter, r *http.Request) {
+		close(startedC)
+		time.Sleep(100 * time.Millisecond)
+		io.WriteString(w, "hello")
+	})
+	// 종료하면 Workerpool이 닫히므로 다른 테스트와 따로 사용
+	oldPool := Workerpool
+	InitWorkerpool()
+	t.Cleanup(func() { Workerpool = oldPool })
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &http.Server{Handler: handler}
+	go server.Serve(listener)
+	adminListener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	adminServer := &http.Server{Handler: http.NotFoundHandler()}
+	go adminServer.Serve(adminListener)
+	bg := newBackgroundTasks()
+	var bgStopped atomic.Bool
+	bg.Go(func(ctx context.Context) {
+		<-ctx.Done()
+		bgStopped.Store(true)
+	})
+
+	bodyC := make(chan string, 1)
+	go func() {
+		req, _ := http.NewRequest(http.MethodGet, "http://"+listener.Addr().String()+"/shutdown", nil)
+		req.Host = GLOBAL_HOST
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			bodyC <- err.Error()
+			return
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		bodyC <- string(body)
+	}()
+	<-startedC
+
+	var taskDone atomic.Bool
+	Workerpool.AddTask(func() {
+		time.Sleep(50 * time.Millisecond)
+		taskDone.Store(true)
+	})
+
+	// 처리 중인 요청과 Workerpool 작업이 끝난 뒤에 돌아옴
+	shutdown(server, adminServer, bg)
+	if body := <-bodyC; body != "hello" || !taskDone.Load() {
+		t.Errorf("body %q, task done %v", body, taskDone.Load())
+	}
+	for _, addr := range []string{listener.Addr().String(), adminListener.Addr().String()} {
+		if _, err := http.Get("http://" + addr + "/"); err == nil {
+			t.Errorf("%s must not accept new connections", addr)
+		}
+	}
+	// 로그를 남기는 goroutine은 멈추고, 종료 후에는 작업을 받지 않음
+	if !bgStopped.Load() || Workerpool.AddTask(func() {}) {
+		t.Errorf("background stopped %v, task accepted after shutdown", bgStopped.Load())
+	}
+}
+
+func TestCoalescing(t *testing.T) {
+	isInFlight := func() bool {
+		inFlightMutex.Lock()
+		defer inFlightMutex.Unlock()
+		return len(inFlight) > 0
+	}
+
+	t.Run("leader abort", func(t *testing.T) {
+		releaseC := make(chan struct{})
+		handler := newTestProxy(t, ConfigStruct{CoalescingWaitTimeout: 5000}, func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Cache-Control", "max-age=60")
+			w.Header().Set("Content-Type", "text/html")
+			w.Write(make([]byte, 1000))
+			w.(http.Flusher).Flush()
+			<-releaseC
+			for range 100 {
+				if _, err := w.Write(make([]byte, 1<<16)); err != nil {
+					return
+				}
+			}
+		})
+		// Client 연결이 끊기면 ReverseProxy는 http.Server 안에서만 panic함
+		server := httptest.NewServer(handler)
+		defer server.Close()
+		defer close(releaseC)
+
+		req, _ := http.NewRequest(http.MethodGet, server.URL+"/leader-abort", nil)
+		req.Host = GLOBAL_HOST
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.ReadFull(resp.Body, make([]byte, 1000))
+		resp.Body.Close()
+		releaseC <- struct{}{}
+
+		deadline := time.Now().Add(2 * time.Second)
+		for isInFlight() && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+		if isInFlight() {
+			t.Error("flight of the aborted leader not finished")
+		}
+	})
+
+	t.Run("uncacheable leader", func(t *testing.T) {
+		var originRequests atomic.Int32
+		startedC := make(chan struct{})
+		handler := newTestProxy(t, ConfigStruct{CoalescingWaitTimeout: 5000}, func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Cache-Control", "no-store")
+			w.Header().Set("Content-Type", "text/html")
+			if originRequests.Add(1) == 1 {
+				w.(http.Flusher).Flush()
+				close(startedC)
+				time.Sleep(500 * time.Millisecond)
+			}
+			io.WriteString(w, "hello")
+		})
+
+		url := "http://" + GLOBAL_HOST + "/uncacheable-leader"
+		leaderDoneC := make(chan struct{})
+		go func() {
+			serveTestRequest(handler, http.MethodGet, url)
+			close(leaderDoneC)
+		}()
+		<-startedC
+		startTime := time.Now()
+		waiter := serveTestRequest(handler, http.MethodGet, url)
+		// leader가 본문을 다 받을 때까지 기다리지 않고 바로 Origin으로 감
+		if elapsed := time.Since(startTime); elapsed > 300*time.Millisecond || waiter.Body.String() != "hello" || originRequests.Load() != 2 {
+			t.Errorf("waiter took %s, body %q, origin requests %d", elapsed, waiter.Body.String(), originRequests.Load())
+		}
+		<-leaderDoneC
+	})
+
+	t.Run("waiter timeout", func(t *testing.T) {
+		var originRequests atomic.Int32
+		startedC := make(chan struct{})
+		handler := newTestProxy(t, ConfigStruct{CoalescingWaitTimeout: 100}, func(w http.ResponseWriter, r *http.Request) {
+			if originRequests.Add(1) == 1 {
+				close(startedC)
+				time.Sleep(time.Second)
+			}
+			w.Header().Set("Cache-Control", "max-age=60")
+			w.Header().Set("Content-Type", "text/html")
+			io.WriteString(w, "hello")
+		})
+
+		url := "http://" + GLOBAL_HOST + "/waiter-timeout"
+		leaderDoneC := make(chan struct{})
+		go func() {
+			serveTestRequest(handler, http.MethodGet, url)
+			close(leaderDoneC)
+		}()
+		<-startedC
+		startTime := time.Now()
+		waiter := serveTestRequest(handler, http.MethodGet, url)
+		if elapsed := time.Since(startTime); elapsed > 800*time.Millisecond || waiter.Body.String() != "hello" || originRequests.Load() != 2 {
+			t.Errorf("waiter took %s, body %q, origin requests %d", elapsed, waiter.Body.String(), originRequests.Load())
+		}
+		<-leaderDoneC
+	})
+
+	t.Run("waiter canceled", func(t *testing.T) {
+		var originRequests atomic.Int32
+		startedC, releaseC := make(chan struct{}), make(chan struct{})
+		handler := newTestProxy(t, ConfigStruct{CoalescingWaitTimeout: 5000}, func(w http.ResponseWriter, r *http.Request) {
+			if originRequests.Add(1) == 1 {
+				close(startedC)
+				<-releaseC
+			}
+			w.Header().Set("Cache-Control", "max-age=60")
+			w.Header().Set("Content-Type", "text/html")
+			io.WriteString(w, "hello")
+		})
+
+		url := "http://" + GLOBAL_HOST + "/waiter-canceled"
+		leaderDoneC := make(chan struct{})
+		go func() {
+			serveTestRequest(handler, http.MethodGet, url)
+			close(leaderDoneC)
+		}()
+		<-startedC
+
+		// 연결이 끊긴 Client는 CoalescingWaitTimeout까지 기다리지 않음
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		startTime := time.Now()
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, url, nil).WithContext(ctx))
+		if elapsed := time.Since(startTime); elapsed > time.Second {
+			t.Errorf("canceled waiter took %s", elapsed)
+		}
+		close(releaseC)
+		<-leaderDoneC
+	})
+}
+
+func TestStaleContent(t *testing.T) {
+	t.Run("stale-while-revalidate", func(t *testing.T) {
+		var version atomic.Int32
+		version.Store(1)
+		bgHeaderC := make(chan http.Header, 1)
+		handler := newTestProxy(t, ConfigStruct{}, func(w http.ResponseWriter, r *http.Request) {
+			v := version.Load()
+			if v == 2 {
+				bgHeaderC <- r.Header.Clone()
+			}
+			w.Header().Set("Cache-Control", "max-age=60, stale-while-revalidate=60")
+			w.Header().Set("Content-Type", "text/html")
+			w.Header().Set("Etag", fmt.Sprintf(`"v%d"`, v))
+			fmt.Fprintf(w, "version %d", v)
+		})
+
+		url := "http://" + GLOBAL_HOST + "/swr"
+		serveTestRequest(handler, http.MethodGet, url)
+		Workerpool.Wait()
+		purge(PurgeRequest{URL: url, Soft: true})
+		version.Store(2)
+
+		// Client의 Range, If-None-Match는 갱신 요청에 섞이지 않아야 함
+		req := httptest.NewRequest(http.MethodGet, url, nil)
+		req.Header.Set("Range", "bytes=0-1")
+		req.Header.Set("If-None-Match", `"client"`)
+		stale := httptest.NewRecorder()
+		handler.ServeHTTP(stale, req)
+		if stale.Code != http.StatusPartialContent || stale.Body.String() != "ve" || !strings.Contains(stale.Header().Get("Cache-Status"), "hit; ttl=-") {
+			t.Errorf("stale response %d %q %v", stale.Code, stale.Body.String(), stale.Header())
+		}
+
+		bgHeader := <-bgHeaderC
+		if bgHeader.Get("Range") != "" || bgHeader.Get("If-None-Match") != `"v1"` {
+			t.Errorf("background request header %v", bgHeader)
+		}
+		Workerpool.Wait()
+		if hit := serveTestRequest(handler, http.MethodGet, url); hit.Body.String() != "version 2" {
+			t.Errorf("not refreshed : %q", hit.Body.String())
+		}
+	})
+
+	t.Run("stale-if-error", func(t *testing.T) {
+		var failing atomic.Bool
+		handler := newTestProxy(t, ConfigStruct{}, func(w http.ResponseWriter, r *http.Request) {
+			if failing.Load() {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Cache-Control", "max-age=60, stale-if-error=60")
+			w.Header().Set("Content-Type", "text/html")
+			io.WriteString(w, "hello")
+		})
+
+		url := "http://" + GLOBAL_HOST + "/stale-if-error"
+		serveTestRequest(handler, http.MethodGet, url)
+		Workerpool.Wait()
+		purge(PurgeRequest{URL: url, Soft: true})
+		failing.Store(true)
+
+		stale := serveTestRequest(handler, http.MethodGet, url)
+		if stale.Code != http.StatusOK || stale.Body.String() != "hello" || !strings.Contains(stale.Header().Get("Cache-Status"), "fwd=stale; fwd-status=500; ttl=-") {
+			t.Errorf("stale response %d %q %v", stale.Code, stale.Body.String(), stale.Header())
+		}
+	})
+}
+
+func TestRangeRequest(t *testing.T) {
+	originRangeC := make(chan string, 10)
+	origin := func(w http.ResponseWriter, r *http.Request) {
+		originRangeC <- r.Header.Get("Range")
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("Content-Type", "text/plain")
+		http.ServeContent(w, r, "", time.Time{}, strings.NewReader("0123456789"))
+	}
+	serveRange := func(handler http.Handler, url string, rangeHeader string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, url, nil)
+		req.Header.Set("Range", rangeHeader)
+		res := httptest.NewRecorder()
+		handler.ServeHTTP(res, req)
+		return res
+	}
+
+	// 저장할 수 있으면 Origin에서 전체를 받아 저장하고 범위만 보냄
+	handler := newTestProxy(t, ConfigStruct{}, origin)
+	url := "http://" + GLOBAL_HOST + "/range"
+	res := serveRange(handler, url, "bytes=2-4")
+	if res.Code != http.StatusPartialContent || res.Body.String() != "234" || res.Header().Get("Content-Range") != "bytes 2-4/10" {
+		t.Errorf("miss range %d %q %v", res.Code, res.Body.String(), res.Header())
+	}
+	if originRange := <-originRangeC; originRange != "" {
+		t.Errorf("origin got Range %q", originRange)
+	}
+	Workerpool.Wait()
+	if hit := serveTestRequest(handler, http.MethodGet, url); hit.Body.String() != "0123456789" || !strings.HasPrefix(hit.Header().Get("Cache-Status"), "jnlee; hit") {
+		t.Errorf("not stored : %q %v", hit.Body.String(), hit.Header())
+	}
+
+	res = serveRange(handler, "http://"+GLOBAL_HOST+"/unsatisfiable", "bytes=20-")
+	if res.Code != http.StatusRequestedRangeNotSatisfiable || res.Header().Get("Content-Range") != "bytes */10" {
+		t.Errorf("unsatisfiable range %d %v", res.Code, res.Header())
+	}
+	<-originRangeC
+
+	// 저장하지 않는 요청과 여러 범위는 Origin에 Range를 보내고 Origin의 206을 전달
+	handler = newTestProxy(t, ConfigStruct{CacheExceptions: []string{"/exception"}}, origin)
+	for _, tc := range []struct{ url, rangeHeader string }{
+		{"http://" + GLOBAL_HOST + "/exception", "bytes=2-4"},
+		{"http://" + GLOBAL_HOST + "/multi", "bytes=0-1,8-9"},
+	} {
+		res = serveRange(handler, tc.url, tc.rangeHeader)
+		if originRange := <-originRangeC; originRange != tc.rangeHeader || res.Code != http.StatusPartialContent {
+			t.Errorf("%s : origin Range %q, %d", tc.url, originRange, res.Code)
+		}
+	}
+}
+
+func TestOriginContentEncoding(t *testing.T) {
+	var requests atomic.Int32
+	brBody := "\x0b\x02\x80hello\x03"
+	handler := newTestProxy(t, ConfigStruct{}, func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("Content-Type", "text/html")
+		w.Header().Set("Content-Encoding", "br")
+		io.WriteString(w, brBody)
+	})
+
+	// br 응답은 압축을 풀 수 없으므로 저장하지 않고, 받은 그대로 Content-Encoding과 함께 보냄
+	url := "http://" + GLOBAL_HOST + "/br"
+	for _, acceptEncoding := range []string{"br", "identity"} {
+		req := httptest.NewRequest(http.MethodGet, url, nil)
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+		res := httptest.NewRecorder()
+		handler.ServeHTTP(res, req)
+		Workerpool.Wait()
+		if res.Header().Get("Content-Encoding") != "br" || res.Body.String() != brBody || !strings.Contains(res.Header().Get("Cache-Status"), `detail="content_encoding"`) {
+			t.Errorf("%s : %v, %q", acceptEncoding, res.Header(), res.Body.String())
+		}
+	}
+	if requests.Load() != 2 {
+		t.Errorf("origin requests %d, br response must not be cached", requests.Load())
+	}
+}
+
+func TestAuthorizedRequest(t *testing.T) {
+	var requests atomic.Int32
+	handler := newTestProxy(t, ConfigStruct{}, func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.Header().Set("Cache-Control", r.URL.Query().Get("cc"))
+		w.Header().Set("Content-Type", "text/html")
+		io.WriteString(w, "secret")
+	})
+
+	// Origin이 public, s-maxage, must-revalidate로 허용한 경우만 저장
+	dummy := map[string]bool{
+		"max-age=60":                 false,
+		"public,max-age=60":          true,
+		"s-maxage=60":                true,
+		"must-revalidate,max-age=60": true,
+	}
+	for cc, stored := range dummy {
+		requests.Store(0)
+		url := "http://" + GLOBAL_HOST + "/auth?cc=" + cc
+		for range 2 {
+			req := httptest.NewRequest(http.MethodGet, url, nil)
+			req.Header.Set("Authorization", "Bearer token")
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+			Workerpool.Wait()
+		}
+		if stored && requests.Load() != 1 || !stored && requests.Load() != 2 {
+			t.Errorf("%s : origin requests %d", cc, requests.Load())
+		}
+	}
+}
+
+func TestClientNoCache(t *testing.T) {
+	var requests atomic.Int32
+	handler := newTestProxy(t, ConfigStruct{}, func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.Header().Set("Cache-Control", r.URL.Query().Get("cc"))
+		w.Header().Set("Content-Type", "text/html")
+		w.Header().Set("Etag", `"v1"`)
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		io.WriteString(w, "hello")
+	})
+
+	// 만료되지 않은 캐시도 Client가 no-cache로 요청하면 Origin에 확인하고, immutable이면 확인하지 않음
+	dummy := map[string]int32{
+		"max-age=60":           2,
+		"max-age=60,immutable": 1,
+	}
+	for cc, want := range dummy {
+		requests.Store(0)
+		url := "http://" + GLOBAL_HOST + "/no-cache?cc=" + cc
+		serveTestRequest(handler, http.MethodGet, url)
+		Workerpool.Wait()
+
+		req := httptest.NewRequest(http.MethodGet, url, nil)
+		req.Header.Set("Cache-Control", "no-cache")
+		res := httptest.NewRecorder()
+		handler.ServeHTTP(res, req)
+		Workerpool.Wait()
+		if requests.Load() != want || res.Code != http.StatusOK || res.Body.String() != "hello" {
+			t.Errorf("%s : origin requests %d, %d %q %v", cc, requests.Load(), res.Code, res.Body.String(), res.Header())
+		}
+		if want == 2 && !strings.Contains(res.Header().Get("Cache-Status"), "fwd=request; fwd-status=304") {
+			t.Errorf("%s : Cache-Status %s", cc, res.Header().Get("Cache-Status"))
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package wcs
+
+import (
+	"encoding/json"
+	"fmt"
+	"jnlee/cache"
+	"maps"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// 삭제할 캐시의 조건. URL이 있으면 그 URL만 key로 바로 찾고,
+// 없으면 나머지 조건을 모두 만족하는 캐시를 찾음
+type PurgeRequest struct {
+	URL     string `json:"url"`     // 정확히 일치하는 URL (모든 variant 포함)
+	Host    string `json:"host"`    // Host가 일치하는 캐시
+	Prefix  string `json:"prefix"`  // path가 prefix로 시작하는 캐시
+	Tag     string `json:"tag"`     // Origin이 Surrogate-Key 헤더로 붙인 tag
+	Pattern string `json:"pattern"` // URL이 정규 표현식과 일치하는 캐시
+	Soft    bool   `json:"soft"`    // 삭제하지 않고 만료시켜 다음 요청 때 재검증하게 함
+}
+
+type PurgeResult struct {
+	Matched int    `json:"matched"`
+	Soft    bool   `json:"soft"`
+	Error   string `json:"error,omitempty"`
+}
+
+// POST(또는 DELETE) /purge. 조건은 JSON 본문 또는 query로 받음
+func handlePurge(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodDelete {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	req, err := parsePurgeRequest(r)
+	result := PurgeResult{Soft: req.Soft}
+	if err == nil {
+		result.Matched, err = purge(req)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err != nil {
+		myLogger.Warnf("Purge failed (%s) : %v\n", getRequester(r), err)
+		result.Error = err.Error()
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(result)
+		return
+	}
+	myLogger.Infof("Purge (%s) : %+v, %d items\n", getRequester(r), req, result.Matched)
+	json.NewEncoder(w).Encode(result)
+}
+
+func parsePurgeRequest(r *http.Request) (req PurgeRequest, err error) {
+	if r.ContentLength != 0 && r.Body != nil {
+		err = json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			return req, fmt.Errorf("invalid purge request : %v", err)
+		}
+		return req, nil
+	}
+	query := r.URL.Query()
+	req = PurgeRequest{
+		URL:     query.Get("url"),
+		Host:    query.Get("host"),
+		Prefix:  query.Get("prefix"),
+		Tag:     query.Get("tag"),
+		Pattern: query.Get("pattern"),
+		Soft:    query.Get("soft") == "true",
+	}
+	return req, nil
+}
+
+func purge(req PurgeRequest) (matched int, err error) {
+	if req.URL != "" {
+		target, err := url.Parse(req.URL)
+		if err != nil || target.Host == "" {
+			return 0, fmt.Errorf("invalid url %q", req.URL)
+		}
+		// 저장할 때와 같은 key가 되도록 GET 요청으로 만듦
+		getReq := &http.Request{Method: http.MethodGet, Host: target.Host, URL: &url.URL{Path: target.Path, RawQuery: target.RawQuery}}
+		return purgeURI(GetURI(getReq), req.URL, req.Soft, "Purge"), nil
+	}
+	if req.Host == "" && req.Prefix == "" && req.Tag == "" && req.Pattern == "" {
+		return 0, fmt.Errorf("url, host, prefix, tag or pattern is required")
+	}
+
+	var pattern *regexp.Regexp
+	if req.Pattern != "" {
+		pattern, err = regexp.Compile(req.Pattern)
+		if err != nil {
+			return 0, fmt.Errorf("invalid pattern %q : %v", req.Pattern, err)
+		}
+	}
+	for _, cd := range myCache.GetAll() {
+		if isPurgeMatched(cd.Ci, req, pattern) && purgeEntry(cd.HashKey, cd.Sha256, cd.Ci, req.Soft, "Purge") {
+			matched += 1
+		}
+	}
+	return matched, nil
+}
+
+func isPurgeMatched(ci cache.CacheItem, req PurgeRequest, pattern *regexp.Regexp) bool {
+	if req.Host != "" && ci.Host != req.Host {
+		return false
+	}
+	if req.Prefix != "" {
+		ciURL, err := url.Parse(ci.URL)
+		if err != nil || !strings.HasPrefix(ciURL.Path, req.Prefix) {
+			return false
+		}
+	}
+	if req.Tag != "" && !slices.Contains(GetSurrogateKeys(ci.Header), req.Tag) {
+		return false
+	}
+	return pattern == nil || pattern.MatchString(ci.URL)
+}
+
+// Surrogate-Key 헤더의 tag들. 공백으로 구분
+func GetSurrogateKeys(header http.Header) []string {
+	keys := []string{}
+	for _, value := range header.Values("Surrogate-Key") {
+		keys = append(keys, strings.Fields(value)...)
+	}
+	return keys
+}
+
+// uri로 저장된 캐시와 그 variant들. 목록을 보지 않고 key로 바로 찾음
+func purgeURI(uri string, url string, soft bool, logMsg string) (matched int) {
+	primaryKey := GetSha256(uri)
+	targets := map[string]int{primaryKey: GetHashkey(uri)}
+	if soft {
+		maps.Copy(targets, getVariants(primaryKey))
+	} else {
+		maps.Copy(targets, unregisterPrimary(primaryKey))
+	}
+
+	for sha256, hashKey := range targets {
+		if purgeEntry(hashKey, sha256, cache.CacheItem{URL: url}, soft, logMsg) {
+			matched += 1
+		}
+	}
+	return matched
+}
+
+// 캐시를 삭제하거나 (soft) 만료시킴. 캐시가 없으면 false
+func purgeEntry(hashKey int, sha256 string, ci cache.CacheItem, soft bool, logMsg string) bool {
+	if !soft {
+		if !myCache.Exists(hashKey, sha256) {
+			return false
+		}
+		removeCacheFile(cache.CacheData{HashKey: hashKey, Sha256: sha256, Ci: ci}, logMsg)
+		return true
+	}
+
+	// 본문은 읽지 않고 유효시간만 바꿈
+	var url string
+	exist := myCache.UpdateMeta(hashKey, sha256, func(stored *cache.CacheItem) {
+		if isFresh(*stored) {
+			stored.ExpirationTime = time.Now()
+		}
+		url = stored.URL
+	})
+	if !exist {
+		return false
+	}
+	myLogger.Infof("%s) 캐시가 만료되었습니다 : %s\n", logMsg, url)
+	return true
+}
@@ -0,0 +1,271 @@
+package wcs
+
+import (
+	"bytes"
+	"cmp"
+	"errors"
+	"fmt"
+	"io"
+	"jnlee/cache"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// 한 요청에 허용하는 Range 개수. 넘으면 Range 헤더를 무시함
+const MAX_RANGES int = 16
+
+var (
+	ErrInvalidRange       = errors.New("invalid range")       // Range 헤더를 무시하고 전체를 보냄
+	ErrUnsatisfiableRange = errors.New("unsatisfiable range") // 416
+)
+
+// 시작과 끝 위치를 모두 포함하는 byte 범위
+type ByteRange struct {
+	Start int64
+	End   int64
+}
+
+func (br ByteRange) contentRange(size int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", br.Start, br.End, size)
+}
+
+// Range 헤더 파싱 (RFC 9110 14.1.2). 크기를 벗어나는 범위는 제외하고, 남는 범위가 없으면 ErrUnsatisfiableRange.
+// 범위가 없거나 MAX_RANGES개를 넘거나 서로 겹치면 ErrInvalidRange
+func ParseRange(rangeHeader string, size int64) ([]ByteRange, error) {
+	specs, ok := strings.CutPrefix(rangeHeader, "bytes=")
+	if !ok {
+		return nil, ErrInvalidRange
+	}
+
+	var ranges []ByteRange
+	specNum := 0
+	for _, spec := range strings.Split(specs, ",") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		specNum++
+		if specNum > MAX_RANGES {
+			return nil, ErrInvalidRange
+		}
+		startStr, endStr, ok := strings.Cut(spec, "-")
+		if !ok {
+			return nil, ErrInvalidRange
+		}
+
+		// suffix-range : 마지막 n bytes
+		if startStr == "" {
+			suffixLength, err := strconv.ParseInt(endStr, 10, 64)
+			if err != nil || suffixLength < 0 {
+				return nil, ErrInvalidRange
+			}
+			if suffixLength == 0 || size == 0 {
+				continue
+			}
+			ranges = append(ranges, ByteRange{max(0, size-suffixLength), size - 1})
+			continue
+		}
+
+		start, err := strconv.ParseInt(startStr, 10, 64)
+		if err != nil || start < 0 {
+			return nil, ErrInvalidRange
+		}
+		end := size - 1
+		if endStr != "" {
+			end, err = strconv.ParseInt(endStr, 10, 64)
+			if err != nil || end < start {
+				return nil, ErrInvalidRange
+			}
+			end = min(end, size-1)
+		}
+		if start >= size {
+			continue
+		}
+		ranges = append(ranges, ByteRange{start, end})
+	}
+
+	if specNum == 0 {
+		return nil, ErrInvalidRange
+	}
+	if len(ranges) == 0 {
+		return nil, ErrUnsatisfiableRange
+	}
+	if isRangeOverlapped(ranges) {
+		return nil, ErrInvalidRange
+	}
+	return ranges, nil
+}
+
+// 겹치는 범위로 같은 부분을 여러 번 요청하는 경우
+func isRangeOverlapped(ranges []ByteRange) bool {
+	sorted := slices.Clone(ranges)
+	slices.SortFunc(sorted, func(a, b ByteRange) int { return cmp.Compare(a.Start, b.Start) })
+	for i := 1; i < len(sorted); i++ {
+		if sorted[i].Start <= sorted[i-1].End {
+			return true
+		}
+	}
+	return false
+}
+
+// If-Range가 없거나 저장된 Etag(strong) 또는 Last-Modified와 같으면 true (RFC 9110 13.1.5)
+func IsIfRangeMatched(ifRange string, storedHeader http.Header) bool {
+	if ifRange == "" {
+		return true
+	}
+	if strings.HasPrefix(ifRange, `"`) || strings.HasPrefix(ifRange, "W/") {
+		etag := storedHeader.Get("Etag")
+		return !strings.HasPrefix(ifRange, "W/") && !strings.HasPrefix(etag, "W/") && ifRange == etag
+	}
+	lastModified := storedHeader.Get("Last-Modified")
+	return lastModified != "" && ifRange == lastModified
+}
+
+// 206 응답의 헤더와 본문. 범위가 여러 개면 multipart/byteranges
+func GetRangeContent(body []byte, contentType string, ranges []ByteRange) (http.Header, []byte) {
+	size := int64(len(body))
+	header := http.Header{}
+
+	if len(ranges) == 1 {
+		br := ranges[0]
+		header.Set("Content-Range", br.contentRange(size))
+		if contentType != "" {
+			header.Set("Content-Type", contentType)
+		}
+		return header, body[br.Start : br.End+1]
+	}
+
+	buf := &bytes.Buffer{}
+	mw := multipart.NewWriter(buf)
+	for _, br := range ranges {
+		partHeader := textproto.MIMEHeader{}
+		if contentType != "" {
+			partHeader.Set("Content-Type", contentType)
+		}
+		partHeader.Set("Content-Range", br.contentRange(size))
+		part, _ := mw.CreatePart(partHeader)
+		part.Write(body[br.Start : br.End+1])
+	}
+	mw.Close()
+	header.Set("Content-Type", "multipart/byteranges; boundary="+mw.Boundary())
+	return header, buf.Bytes()
+}
+
+// Client가 보낸 Range를 캐시된 본문에 적용해서 응답. Range를 적용하지 않았으면 false
+func responseRange(cacheItem cache.CacheItem, w http.ResponseWriter, r *http.Request) bool {
+	rangeHeader := r.Header.Get("Range")
+	if rangeHeader == "" || r.Method != http.MethodGet || !IsIfRangeMatched(r.Header.Get("If-Range"), cacheItem.Header) {
+		return false
+	}
+
+	ranges, err := ParseRange(rangeHeader, int64(len(cacheItem.Body)))
+	switch err {
+	case nil:
+		contentHeader, rangeBody := GetRangeContent(cacheItem.Body, cacheItem.Header.Get("Content-Type"), ranges)
+		for key, values := range contentHeader {
+			w.Header()[key] = values
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(rangeBody)))
+		w.WriteHeader(http.StatusPartialContent)
+		w.Write(rangeBody)
+	case ErrUnsatisfiableRange:
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", len(cacheItem.Body)))
+		w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+	default:
+		return false
+	}
+	return true
+}
+
+// 저장할 수 있는 요청이면 Origin에 전체를 요청해서 캐시를 채움.
+// 여러 범위는 본문을 모아야 보낼 수 있으므로 Origin에 그대로 보냄
+func isRangeFillable(r *http.Request, state *requestState) bool {
+	rangeHeader := r.Header.Get("Range")
+	return r.Method == http.MethodGet && rangeHeader != "" && !strings.Contains(rangeHeader, ",") && !IsCacheException(state.primaryURI)
+}
+
+// Range 요청이 캐시에 없던 경우 Origin에는 전체를 요청하고, 받는 본문에서 Client의 Range만 잘라서 보냄.
+// 저장 중이면 잘라낸 범위 뒤도 끝까지 읽어야 캐시가 완성됨
+func applyClientRange(resp *http.Response, state *requestState, filling bool) {
+	// Range는 압축을 푼 본문 기준이고 전체 크기를 알아야 하므로, 모르면 Range를 무시하고 전체를 보냄
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Encoding") != "" || resp.ContentLength < 0 {
+		return
+	}
+
+	clientReq := state.clientReq
+	size := resp.ContentLength
+	ranges, err := ParseRange(clientReq.Header.Get("Range"), size)
+	if !IsIfRangeMatched(clientReq.Header.Get("If-Range"), resp.Header) {
+		err = ErrInvalidRange
+	}
+	rr := &rangeReader{body: resp.Body, drain: filling}
+	switch err {
+	case nil:
+		br := ranges[0]
+		rr.skip, rr.remain = br.Start, br.End-br.Start+1
+		resp.Header.Set("Content-Range", br.contentRange(size))
+		resp.StatusCode = http.StatusPartialContent
+		resp.Status = "206 Partial Content"
+	case ErrUnsatisfiableRange:
+		resp.Header.Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+		resp.StatusCode = http.StatusRequestedRangeNotSatisfiable
+		resp.Status = "416 Range Not Satisfiable"
+	default:
+		return
+	}
+
+	resp.Header.Set("Content-Length", strconv.FormatInt(rr.remain, 10))
+	resp.ContentLength = rr.remain
+	resp.Body = rr
+}
+
+// body에서 skip만큼 버리고 remain만큼만 읽음. drain이면 마지막에 나머지를 모두 읽어서 버림
+type rangeReader struct {
+	body   io.ReadCloser
+	skip   int64
+	remain int64
+	drain  bool
+}
+
+func (rr *rangeReader) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	for rr.skip > 0 {
+		n, err := rr.body.Read(p[:min(int64(len(p)), rr.skip)])
+		rr.skip -= int64(n)
+		if err == io.EOF {
+			return 0, io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+	if rr.remain <= 0 {
+		if rr.drain {
+			if _, err := io.Copy(io.Discard, rr.body); err != nil {
+				return 0, err
+			}
+		}
+		return 0, io.EOF
+	}
+
+	n, err := rr.body.Read(p[:min(int64(len(p)), rr.remain)])
+	rr.remain -= int64(n)
+	if err == io.EOF && rr.remain > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+	if err == io.EOF {
+		// 범위 끝과 본문 끝이 같으면 여기서 끝남
+		err = nil
+	}
+	return n, err
+}
+
+func (rr *rangeReader) Close() error {
+	return rr.body.Close()
+}
@@ -0,0 +1,148 @@
+package wcs
+
+import (
+	"bytes"
+	"io"
+	"jnlee/cache"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func isFresh(ci cache.CacheItem) bool {
+	return time.Now().Before(ci.ExpirationTime)
+}
+
+// Client가 Cache-Control: no-cache 또는 max-age=0으로 저장된 캐시를 확인하도록 요청했는지 (RFC 9111 5.2.1)
+func isValidationRequested(header http.Header) bool {
+	cc := GetCacheControl(header)
+	seconds, ok := cc.Seconds("max-age")
+	return cc.Has("no-cache") || (ok && seconds == 0)
+}
+
+// 만료되지 않았고 Client가 재검증을 요청하지 않았으면 Origin에 확인하지 않고 사용.
+// immutable인 응답은 유효시간 동안 바뀌지 않으므로 재검증 요청도 무시함 (RFC 8246)
+func (state *requestState) isUsable(ci cache.CacheItem) bool {
+	if !isFresh(ci) {
+		return false
+	}
+	return !state.noCache || GetCacheControl(ci.Header).Has("immutable")
+}
+
+func hasValidator(header http.Header) bool {
+	return header.Get("Etag") != "" || header.Get("Last-Modified") != ""
+}
+
+// 저장된 Etag, Last-Modified로 Origin에 보낼 조건부 요청 헤더 설정
+func SetConditionalHeaders(req *http.Request, storedHeader http.Header) {
+	req.Header.Del("If-None-Match")
+	req.Header.Del("If-Modified-Since")
+	if etag := storedHeader.Get("Etag"); etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	if lastModified := storedHeader.Get("Last-Modified"); lastModified != "" {
+		req.Header.Set("If-Modified-Since", lastModified)
+	}
+}
+
+// Origin이 304를 보낸 경우 본문은 그대로 두고 헤더와 유효시간만 갱신한 뒤, Client에는 저장된 본문으로 응답
+func refreshCacheItem(resp *http.Response, state *requestState) {
+	ci := *state.staleItem
+	ci.Header = MergeNotModifiedHeader(ci.Header, resp.Header)
+	responseTime := time.Now()
+	ci.ExpirationTime = GetExpirationTime(ci.Header, state.vhost.config, responseTime)
+	ci.CachedTime = responseTime
+	ci.InitialAge = GetInitialAge(resp.Header, responseTime)
+
+	// 본문과 압축된 본문은 저장된 것을 그대로 둠
+	state.queueCacheTask(func() {
+		myCache.UpdateMeta(state.hashKey, state.sha256, func(stored *cache.CacheItem) {
+			stored.Header, stored.ExpirationTime, stored.CachedTime, stored.InitialAge = ci.Header, ci.ExpirationTime, ci.CachedTime, ci.InitialAge
+		})
+	})
+	myLogger.Debugf("Revalidated : %s\n", state.url)
+
+	resp.Body.Close()
+	state.result = RESULT_REVALIDATED
+	state.expirationTime = ci.ExpirationTime
+	if state.clientReq != nil && IsNotModified(state.clientReq.Header, ci.Header) {
+		resp.Header = notModifiedHeader(ci)
+		resp.Body = http.NoBody
+		notModifiedTotal.With(state.host).Inc()
+		return
+	}
+
+	setResponseFromCache(resp, ci)
+}
+
+// Origin의 응답을 저장된 캐시의 헤더와 본문으로 바꿈
+func setResponseFromCache(resp *http.Response, ci cache.CacheItem) {
+	resp.StatusCode = http.StatusOK
+	resp.Status = "200 OK"
+	resp.Header = http.Header{}
+	setHitHeader(resp.Header, ci) // 저장된 본문은 압축이 풀려 있으므로 Content-Encoding은 보내지 않음
+	resp.Header.Set("Content-Length", strconv.Itoa(len(ci.Body)))
+	resp.ContentLength = int64(len(ci.Body))
+	resp.Body = io.NopCloser(bytes.NewReader(ci.Body))
+}
+
+// 304 응답의 헤더로 저장된 헤더를 갱신. 본문에 대한 헤더는 바꾸지 않음
+func MergeNotModifiedHeader(stored http.Header, notModified http.Header) http.Header {
+	merged := stored.Clone()
+	for key, values := range notModified {
+		switch key {
+		case "Content-Length", "Content-Encoding", "Transfer-Encoding", "Content-Range":
+			continue
+		}
+		merged[key] = values
+	}
+	return merged
+}
+
+// Client의 If-None-Match, If-Modified-Since를 저장된 Etag, Last-Modified와 비교 (RFC 9110 13.1)
+func IsNotModified(reqHeader http.Header, storedHeader http.Header) bool {
+	if ifNoneMatch := reqHeader.Get("If-None-Match"); ifNoneMatch != "" {
+		etag := storedHeader.Get("Etag")
+		if etag == "" {
+			return false
+		}
+		for _, candidate := range strings.Split(ifNoneMatch, ",") {
+			candidate = strings.TrimSpace(candidate)
+			if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+				return true
+			}
+		}
+		return false
+	}
+
+	ifModifiedSince, err := http.ParseTime(reqHeader.Get("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
+	lastModified, err := http.ParseTime(storedHeader.Get("Last-Modified"))
+	if err != nil {
+		return false
+	}
+	return !lastModified.After(ifModifiedSince)
+}
+
+// 304 응답에 포함할 헤더 (RFC 9110 15.4.5)
+func notModifiedHeader(ci cache.CacheItem) http.Header {
+	header := http.Header{}
+	for _, key := range []string{"Cache-Control", "Content-Location", "Etag", "Expires", "Last-Modified", "Vary"} {
+		if value := ci.Header.Get(key); value != "" {
+			header.Set(key, value)
+		}
+	}
+	return header
+}
+
+func responseNotModified(ci cache.CacheItem, w http.ResponseWriter) {
+	for key, values := range notModifiedHeader(ci) {
+		w.Header()[key] = values
+	}
+	w.Header().Set("Age", strconv.Itoa(getAge(ci)))
+	w.Header().Add("jnlee", "HIT")
+	w.WriteHeader(http.StatusNotModified)
+}
@@ -0,0 +1,86 @@
+package wcs
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Config에 ShutdownTimeout이 없을 때 요청과 캐시 저장 작업을 기다리는 시간 (초)
+const DEFAULT_SHUTDOWN_TIMEOUT int = 30
+
+func getShutdownTimeout() time.Duration {
+	seconds := GetConfig().ShutdownTimeout
+	if seconds == 0 {
+		seconds = DEFAULT_SHUTDOWN_TIMEOUT
+	}
+	return time.Second * time.Duration(seconds)
+}
+
+// 로그를 남기는 goroutine들. 종료할 때 로그 파일을 닫기 전에 멈춤
+type backgroundTasks struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func newBackgroundTasks() *backgroundTasks {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &backgroundTasks{ctx: ctx, cancel: cancel}
+}
+
+// task는 ctx가 끝나면 돌아와야 함
+func (bt *backgroundTasks) Go(task func(ctx context.Context)) {
+	bt.wg.Add(1)
+	go func() {
+		defer bt.wg.Done()
+		task(bt.ctx)
+	}()
+}
+
+func (bt *backgroundTasks) Stop() {
+	bt.cancel()
+	bt.wg.Wait()
+}
+
+// SIGTERM, SIGINT를 받으면 server를 종료함. 종료 중에 한 번 더 받으면 바로 종료
+func watchShutdownSignal(server *http.Server, adminServer *http.Server, bg *backgroundTasks) {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	<-ctx.Done()
+	stop()
+	shutdown(server, adminServer, bg)
+}
+
+// 새 연결을 받지 않고, 처리 중인 요청과 Workerpool의 캐시 저장 작업이 끝날 때까지 ShutdownTimeout만큼 기다림.
+// 그 뒤 관리용 server와 bg를 멈춤
+func shutdown(server *http.Server, adminServer *http.Server, bg *backgroundTasks) {
+	timeout := getShutdownTimeout()
+	myLogger.Infof("Shutting down : waiting for requests (timeout %s)\n", timeout)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if err := server.Shutdown(ctx); err != nil {
+		myLogger.Warnf("Shutdown : requests not finished (%v)\n", err)
+	}
+
+	doneC := make(chan struct{})
+	go func() {
+		Workerpool.Close()
+		close(doneC)
+	}()
+	select {
+	case <-doneC:
+		myLogger.Infof("Shutdown : cache tasks finished\n")
+	case <-ctx.Done():
+		myLogger.Warnf("Shutdown : cache tasks not finished (%d waiting)\n", Workerpool.QueueDepth())
+	}
+
+	if err := adminServer.Shutdown(ctx); err != nil {
+		adminServer.Close()
+	}
+	bg.Stop()
+}
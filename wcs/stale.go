@@ -0,0 +1,140 @@
+package wcs
+
+import (
+	"bytes"
+	"context"
+	"jnlee/cache"
+	"net/http"
+	"time"
+)
+
+const (
+	STALE_WHILE_REVALIDATE string = "stale-while-revalidate"
+	STALE_IF_ERROR         string = "stale-if-error"
+)
+
+// 만료된 후에도 directive에 따라 캐시를 보낼 수 있는 시간 (RFC 5861).
+// Origin의 Cache-Control 값이 없으면 Host의 기본값을 사용
+func getStaleWindow(ci cache.CacheItem, directive string) time.Duration {
+	cc := GetCacheControl(ci.Header)
+	if cc.Has("must-revalidate") || cc.Has("proxy-revalidate") {
+		return 0
+	}
+	if seconds, ok := cc.Seconds(directive); ok {
+		return time.Duration(seconds) * time.Second
+	}
+
+	vhost, ok := getVirtualHost(ci.Host)
+	if !ok {
+		return 0
+	}
+	switch directive {
+	case STALE_WHILE_REVALIDATE:
+		return time.Duration(vhost.config.StaleWhileRevalidate) * time.Second
+	case STALE_IF_ERROR:
+		return time.Duration(vhost.config.StaleIfError) * time.Second
+	}
+	return 0
+}
+
+func isStaleServable(ci cache.CacheItem, directive string) bool {
+	return time.Now().Before(ci.ExpirationTime.Add(getStaleWindow(ci, directive)))
+}
+
+// 만료된 캐시를 지워도 되는 시각. 재검증이나 stale 응답에 쓸 수 있는 동안은 남겨둠
+func getRemoveTime(ci cache.CacheItem) time.Time {
+	keepTime := max(getStaleWindow(ci, STALE_WHILE_REVALIDATE), getStaleWindow(ci, STALE_IF_ERROR))
+	if hasValidator(ci.Header) {
+		keepTime = max(keepTime, time.Second*time.Duration(GetConfig().StaleRetention))
+	}
+	return ci.ExpirationTime.Add(keepTime)
+}
+
+// stale-while-revalidate : 만료된 캐시로 응답한 뒤 Workerpool에서 Origin에 다시 요청해 캐시를 갱신
+func revalidateInBackground(ci cache.CacheItem, state *requestState, r *http.Request) {
+	f, isLeader := joinFlight(state.sha256)
+	if !isLeader {
+		return // 이미 갱신 중
+	}
+
+	bgState := *state
+	bgState.flight = f
+	bgState.background = true
+	bgState.staleItem = &ci
+	bgState.clientReq = nil
+	outReq := r.Clone(context.WithValue(context.Background(), requestStateKey{}, &bgState))
+	outReq.Method = http.MethodGet // HEAD 요청이어도 GET 캐시를 갱신
+	// Client의 Range, 조건부 헤더로는 206, 304를 받아 캐시를 갱신하지 못하므로 빼고 캐시의 validator만 보냄
+	for _, key := range []string{"Range", "If-Range", "If-None-Match", "If-Modified-Since", "If-Match", "If-Unmodified-Since"} {
+		outReq.Header.Del(key)
+	}
+	if hasValidator(ci.Header) {
+		bgState.revalidating = true
+		SetConditionalHeaders(outReq, ci.Header)
+	}
+
+	// 종료 중이면 갱신하지 않음
+	if !Workerpool.AddTask(func() {
+		defer bgState.finishFlight()
+		bgState.vhost.proxy.ServeHTTP(&discardResponseWriter{header: http.Header{}}, outReq)
+	}) {
+		bgState.finishFlight()
+	}
+}
+
+// stale-if-error : Origin 연결에 실패한 경우 만료된 캐시로 응답
+func proxyErrorHandler(w http.ResponseWriter, r *http.Request, err error) {
+	myLogger.Warnf("Proxy error : %s (%v)\n", r.URL, err)
+
+	state := getRequestState(r)
+	if state != nil && state.staleItem != nil && state.clientReq != nil && isStaleServable(*state.staleItem, STALE_IF_ERROR) {
+		myLogger.Warnf("Serve stale (error) : %s\n", state.url)
+		state.result = RESULT_STALE
+		responseByCacheItem(*state.staleItem, bytes.NewReader(state.staleItem.Body), state, w, state.clientReq)
+		return
+	}
+	if state != nil && !state.background {
+		setCacheStatusHeader(w.Header(), state, state.clientReq)
+	}
+	w.WriteHeader(http.StatusBadGateway)
+}
+
+// stale-if-error : Origin이 5xx로 응답한 경우 만료된 캐시로 바꿔서 응답
+func serveStaleIfError(resp *http.Response, state *requestState) bool {
+	if resp.StatusCode < 500 || state.staleItem == nil || state.clientReq == nil || !isStaleServable(*state.staleItem, STALE_IF_ERROR) {
+		return false
+	}
+	myLogger.Warnf("Serve stale (status %d) : %s\n", resp.StatusCode, state.url)
+	resp.Body.Close()
+	setResponseFromCache(resp, *state.staleItem)
+	state.result = RESULT_STALE
+	state.expirationTime = state.staleItem.ExpirationTime
+	return true
+}
+
+// 캐시 저장 작업. background 요청은 이미 Workerpool 안에서 실행 중이므로 바로 실행
+func (state *requestState) queueCacheTask(task func()) {
+	if state.background {
+		task()
+		return
+	}
+	// 종료 중이라 받지 않으면 저장하지 않고, 기다리는 요청은 호출한 쪽에서 깨움
+	state.cacheQueued = Workerpool.AddTask(func() {
+		task()
+		state.finishFlight()
+	})
+}
+
+type discardResponseWriter struct {
+	header http.Header
+}
+
+func (dw *discardResponseWriter) Header() http.Header {
+	return dw.header
+}
+
+func (dw *discardResponseWriter) Write(b []byte) (int, error) {
+	return len(b), nil
+}
+
+func (dw *discardResponseWriter) WriteHeader(statusCode int) {}
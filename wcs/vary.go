@@ -0,0 +1,165 @@
+package wcs
+
+import (
+	"jnlee/cache"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+)
+
+var (
+	varyIndex   = map[string]*varyEntry{}
+	varyIndexRW sync.RWMutex
+)
+
+// Vary가 있는 URL(primary key)의 Vary 헤더 이름들과 저장된 variant들
+type varyEntry struct {
+	headers  []string
+	variants map[string]int // variant sha256 -> hashKey
+}
+
+// Vary 헤더 값을 헤더 이름 목록으로 변환. "*"가 있으면 isAny = true
+func ParseVary(header http.Header) (headers []string, isAny bool) {
+	for _, value := range header.Values("Vary") {
+		for _, name := range strings.Split(value, ",") {
+			name = strings.TrimSpace(name)
+			switch {
+			case name == "":
+			case name == "*":
+				return nil, true
+			default:
+				name = http.CanonicalHeaderKey(name)
+				if !slices.Contains(headers, name) {
+					headers = append(headers, name)
+				}
+			}
+		}
+	}
+	return headers, false
+}
+
+// primary uri에 요청의 Vary 대상 헤더 값들을 붙인 secondary key용 uri
+func GetVariantURI(uri string, vary []string, reqHeader http.Header) string {
+	var sb strings.Builder
+	sb.WriteString(uri)
+	for _, name := range vary {
+		value := strings.Join(reqHeader.Values(name), ",")
+		value = strings.Join(strings.Fields(value), "")
+		sb.WriteString("\n" + strings.ToLower(name) + ":" + value)
+	}
+	return sb.String()
+}
+
+// 재시작 시 저장소에 남아있는 variant들로 index를 다시 만듦
+func initVaryIndex() {
+	for _, cd := range myCache.GetAll() {
+		if cd.Ci.PrimaryKey != "" {
+			registerVariant(cd.Ci.PrimaryKey, cd.Ci.Vary, cd.Sha256, cd.HashKey)
+		}
+	}
+}
+
+func getVaryHeaders(primaryKey string) []string {
+	varyIndexRW.RLock()
+	defer varyIndexRW.RUnlock()
+	if ve, exist := varyIndex[primaryKey]; exist {
+		return ve.headers
+	}
+	return nil
+}
+
+// variant 등록. Origin의 Vary가 바뀐 경우 예전 variant들을 돌려주며, 호출한 쪽에서 삭제해야 함
+func registerVariant(primaryKey string, headers []string, sha256 string, hashKey int) (oldVariants map[string]int) {
+	varyIndexRW.Lock()
+	defer varyIndexRW.Unlock()
+
+	ve, exist := varyIndex[primaryKey]
+	if !exist || !slices.Equal(ve.headers, headers) {
+		if exist {
+			oldVariants = ve.variants
+			delete(oldVariants, sha256)
+		}
+		ve = &varyEntry{headers, map[string]int{}}
+		varyIndex[primaryKey] = ve
+	}
+	ve.variants[sha256] = hashKey
+	return oldVariants
+}
+
+// primary key에 저장된 variant들의 복사본
+func getVariants(primaryKey string) map[string]int {
+	varyIndexRW.RLock()
+	defer varyIndexRW.RUnlock()
+	if ve, exist := varyIndex[primaryKey]; exist {
+		return maps.Clone(ve.variants)
+	}
+	return nil
+}
+
+func unregisterVariant(primaryKey string, sha256 string) {
+	varyIndexRW.Lock()
+	defer varyIndexRW.Unlock()
+
+	if ve, exist := varyIndex[primaryKey]; exist {
+		delete(ve.variants, sha256)
+		if len(ve.variants) == 0 {
+			delete(varyIndex, primaryKey)
+		}
+	}
+}
+
+// Origin이 더 이상 Vary를 보내지 않는 경우. 남은 variant들을 돌려줌
+func unregisterPrimary(primaryKey string) map[string]int {
+	varyIndexRW.Lock()
+	defer varyIndexRW.Unlock()
+
+	ve, exist := varyIndex[primaryKey]
+	if !exist {
+		return nil
+	}
+	delete(varyIndex, primaryKey)
+	return ve.variants
+}
+
+func removeVariants(variants map[string]int, url string, logMsg string) {
+	for sha256, hashKey := range variants {
+		myCache.Del(hashKey, sha256)
+		myLogger.Infof("%s) 캐시가 삭제되었습니다 : %s (variant)\n", logMsg, url)
+	}
+}
+
+// 요청의 헤더에 맞는 variant를 찾도록 state의 key를 바꿈
+func (state *requestState) selectVariant(reqHeader http.Header) {
+	vary := getVaryHeaders(state.primaryKey)
+	if vary == nil {
+		state.setKey(state.primaryURI)
+		return
+	}
+	state.setKey(GetVariantURI(state.primaryURI, vary, reqHeader))
+}
+
+func (state *requestState) setKey(uri string) {
+	state.uri = uri
+	state.sha256 = GetSha256(uri)
+	state.hashKey = GetHashkey(uri)
+}
+
+// Vary 헤더에 따라 저장할 key와 CacheItem의 Vary 정보를 결정
+func setVariantKey(ci *cache.CacheItem, state *requestState, vary []string, reqHeader http.Header) (hashKey int, sha256 string) {
+	if len(vary) == 0 {
+		removeVariants(unregisterPrimary(state.primaryKey), ci.URL, "Vary removed")
+		return GetHashkey(state.primaryURI), state.primaryKey
+	}
+
+	// Vary 없이 저장돼 있던 캐시는 더 이상 찾지 않으므로 삭제
+	myCache.Del(GetHashkey(state.primaryURI), state.primaryKey)
+
+	uri := GetVariantURI(state.primaryURI, vary, reqHeader)
+	hashKey, sha256 = GetHashkey(uri), GetSha256(uri)
+	ci.Vary = vary
+	ci.PrimaryKey = state.primaryKey
+	removeVariants(registerVariant(state.primaryKey, vary, sha256, hashKey), ci.URL, "Vary changed")
+	return hashKey, sha256
+}
@@ -3,6 +3,7 @@ package wcs
 import (
 	"bytes"
 	"compress/gzip"
+	"context"
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
@@ -16,80 +17,85 @@ import (
 	"net/http/httputil"
 	_ "net/http/pprof"
 	"net/url"
-	"os"
-	"regexp"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
-	"sync"
 	"text/template"
 	"time"
 )
 
 const (
-	GZIP             string = "gzip"
-	GLOBAL_HOST      string = "global.gmarket.co.kr"
-	IMAGE_HOST       string = "image.gmarket.co.kr"
-	CUSTOM_HOST      string = "jn.wcs.co.kr"
-	CACHED           string = " (Cached)"
-	NOT_CACHED       string = " (Not cached)"
-	CONFIG_PATH      string = "./wcs/config.json"
-	WCS_PATH         string = "./wcs/"
-	LOCK_STRING      string = "LOCK"
-	RLOCK_STRING     string = "RLOCK"
-	STORE_TYPE_REDIS string = "redis"
-	STORE_TYPE_FILE  string = "file"
+	GZIP                    string = "gzip"
+	GLOBAL_HOST             string = "global.gmarket.co.kr"
+	CUSTOM_HOST             string = "jn.wcs.co.kr"
+	CACHED                  string = " (Cached)"
+	NOT_CACHED              string = " (Not cached)"
+	CONFIG_PATH             string = "./wcs/config.json"
+	WCS_PATH                string = "./wcs/"
+	DEFAULT_PPROF_ADDR      string = "127.0.0.1:6060"
+	DEFAULT_CACHE_DIR       string = "log_body"
+	LOCK_STRING             string = "LOCK"
+	RLOCK_STRING            string = "RLOCK"
+	STORE_TYPE_REDIS        string = "redis"
+	STORE_TYPE_FILE         string = "file"
+	STORE_TYPE_MEMORY_REDIS string = "memory+redis"
+	STORE_TYPE_MEMORY_FILE  string = "memory+file"
 )
 
 var (
 	myCache    cache.Cache
-	Config     = ConfigStruct{}
 	myLogger   *MyLogger
-	countData  countDatasForStatusPage
 	Workerpool workerpool.WorkerPool
-	isCached   string
-)
-
-type countDatasForStatusPage struct {
-	rwMutex           *sync.RWMutex
-	sendCache         int
-	cachedFile        int
-	gHit              int
-	gRequest          int
-	iHit              int
-	iRequest          int
-	filesizeError     int
-	cacheException    int
-	statusError       int
-	methodError       int
-	cacheControlError int
-	contentTypeError  int
-}
 
-type MyLogger struct {
-	logger *log.Logger
-}
-
-type ConfigStruct struct {
-	MaxFileSize           int64    `json:"MaxFileSize"`
-	GzipEnabled           bool     `json:"GzipEnabled"`
-	CacheExceptions       []string `json:"CacheExceptions"`
-	QueryIgnoreEnabled    bool     `json:"QueryIgnoreEnabled"`
-	QuerySortingEnabled   bool     `json:"QuerySortingEnabled"`
-	ResTimeLoggingEnabled bool     `json:"ResponseTimeLoggingEnabled"`
-	CleanupFrequency      int      `json:"CleanupFrequency"`
-	StoreType             string   `json:"StoreType"`
-}
+	ClearCacheOnStart bool // 시작할 때 저장된 캐시를 모두 지움 (테스트용)
+)
 
-type proxyHandler struct {
-	proxy map[string]*httputil.ReverseProxy
-}
+type proxyHandler struct{}
+
+// 요청을 받은 시점에 계산한 값들. Origin으로 가는 요청의 context에 담아 modifyResponse에서 사용
+type requestState struct {
+	vhost        *virtualHost
+	host         string
+	url          string
+	primaryURI   string // Vary를 적용하기 전의 uri
+	primaryKey   string
+	uri          string
+	sha256       string
+	hashKey      int
+	staleItem    *cache.CacheItem // 재검증 중인 만료된 캐시
+	clientReq    *http.Request    // Client가 보낸 원래 요청
+	flight       *flight          // 이 요청이 leader인 flight
+	cacheQueued  bool             // 캐시 저장 작업이 Workerpool에 들어감
+	revalidating bool             // staleItem의 Etag, Last-Modified로 조건부 요청을 보냄
+	rangeRequest bool             // Client의 Range를 빼고 Origin에 전체를 요청함
+	headUpgrade  bool             // Client의 HEAD를 GET으로 바꿔 Origin에 요청함
+	noCache      bool             // Client가 Cache-Control: no-cache, max-age=0으로 재검증을 요청함
+	background   bool             // stale-while-revalidate로 Workerpool에서 보낸 요청
+	result       string           // 요청 처리 결과. RESULT_*
+	originTime   time.Duration    // Origin 응답 헤더를 받을 때까지 걸린 시간
+
+	// Cache-Status 헤더에 쓰는 값
+	fwd             string    // Origin으로 요청을 보낸 이유. FWD_*. 캐시로 응답하면 빈 문자열
+	fwdStatus       int       // Origin의 응답 status
+	expirationTime  time.Time // 응답에 쓴 캐시의 만료 시각
+	stored          bool      // Origin의 응답을 저장함
+	notCachedReason string    // 저장하지 않은 이유. REASON_*
+}
+
+type requestStateKey struct{}
 
 type HTMLData struct {
 	HitData          []htmlHitData
 	ConfigData       []htmlConfigData
+	ConfigLoadedTime string
+	NotModifiedCount int
+	StaleCount       int
+	InvalidatedCount int
 	CacheData        htmlCacheData
 	ReasonsNotCached htmlReasonsNotCached
+	CacheUsage       htmlCacheUsage
+	TierHits         htmlTierHits
 }
 type htmlHitData struct {
 	Title    string
@@ -103,14 +109,37 @@ type htmlConfigData struct {
 }
 type htmlCacheData struct {
 	ShowImage       bool
-	ImageData       []string
+	ImageData       []htmlCachedURL
 	ImageDataCount  int
 	Images1         []string
 	Images2         []string
 	Images3         []string
-	GlobalData      []string
+	GlobalData      []htmlCachedURL
 	GlobalDataCount int
 }
+type htmlCachedURL struct {
+	URL      string
+	Variants int
+}
+type htmlCacheUsage struct {
+	Bounded   bool
+	Policy    string
+	Items     int
+	MaxItems  int
+	Bytes     int64
+	MaxBytes  int64
+	Evictions int64
+}
+type htmlTierHits struct {
+	Tiered       bool
+	BackendName  string
+	MemoryHits   int64
+	BackendHits  int64
+	Misses       int64
+	MemoryItems  int
+	MemoryBytes  int64
+	MemoryEvicts int64
+}
 type htmlReasonsNotCached struct {
 	FileSizeError     int
 	CacheException    int
@@ -118,6 +147,7 @@ type htmlReasonsNotCached struct {
 	MethodError       int
 	CacheControlError int
 	ContentTypeError  int
+	VaryError         int
 	Total             int
 }
 
@@ -128,108 +158,207 @@ func OpenServer() {
 
 	InitCache()
 	defer myCache.Close()
-
-	initPprofServer()
+	if ClearCacheOnStart {
+		removeDirForTest()
+	}
+	initVaryIndex()
 
 	InitWorkerpool()
 
-	InitCountDatas()
-
-	//For test
-	removeDirForTest()
-
 	logFile := openLoggerFile(WCS_PATH + "log_file.txt")
 	defer logFile.Close()
 	myLogger = generateLogger(logFile)
 
-	// Set ReverseProxy
-	proxyMap := map[string]*httputil.ReverseProxy{
-		GLOBAL_HOST: getReverseProxy(GLOBAL_HOST),
-		IMAGE_HOST:  getReverseProxy(IMAGE_HOST),
-		CUSTOM_HOST: getReverseProxy(CUSTOM_HOST),
-	}
-	pHandler := &proxyHandler{proxyMap}
-	http.Handle("/", pHandler)
+	accessLogFile := openLoggerFile(getAccessLogPath())
+	defer accessLogFile.Close()
+	accessLogger = log.New(accessLogFile, "", 0)
+
+	// 로그를 남기는 goroutine은 종료할 때 로그 파일을 닫기 전에 멈춤
+	bg := newBackgroundTasks()
+
+	// 외부 logrotate가 파일을 옮긴 뒤 SIGUSR1로 다시 열게 함
+	bg.Go(func(ctx context.Context) { watchReopenSignal(ctx, logFile, accessLogFile) })
+
+	adminServer := initPprofServer()
+
+	// Reload config on SIGHUP
+	bg.Go(watchReloadSignal)
 
 	// Set logging
-	go logPerSec()
+	bg.Go(logPerSec)
 
 	// Cleanup Expired Cache
-	go cleanupExpiredCaches()
+	bg.Go(cleanupExpiredCaches)
+
+	// Shutdown on SIGTERM, SIGINT
+	server := &http.Server{Addr: ":80", Handler: &proxyHandler{}}
+	shutdownC := make(chan struct{})
+	go func() {
+		watchShutdownSignal(server, adminServer, bg)
+		close(shutdownC)
+	}()
 
 	// Init Server
 	fmt.Println("Init server!")
-	err := http.ListenAndServe(":80", nil)
-	if err != nil {
+	err := server.ListenAndServe()
+	if err != http.ErrServerClosed {
 		panic(err)
 	}
+	// 요청과 캐시 저장 작업이 끝난 뒤 defer로 캐시(redis 연결)와 로그 파일을 닫음
+	<-shutdownC
+	myLogger.Infof("Server stopped\n")
 }
 
-func initPprofServer() {
+// net/http/pprof는 DefaultServeMux에 등록되므로 프록시와 다른 주소에서 관리용 인증을 거쳐 제공. /metrics도 함께 제공
+func initPprofServer() *http.Server {
+	addr := GetConfig().PprofAddr
+	if addr == "" {
+		addr = DEFAULT_PPROF_ADDR
+	}
+	http.Handle("/metrics", registry)
+	adminServer := &http.Server{Addr: addr, Handler: adminHandler(http.DefaultServeMux)}
 	go func() {
-		http.ListenAndServe(":6060", nil)
+		err := adminServer.ListenAndServe()
+		if err != http.ErrServerClosed {
+			myLogger.Errorf("Pprof server error : %v\n", err)
+		}
 	}()
+	return adminServer
 }
 
-// 디렉토리가 새로 만들어지는지 확인하기 위해, 프로그램 시작 시 기존 디렉토리 삭제
+// 디렉토리가 새로 만들어지는지 확인하기 위해, 프로그램 시작 시 기존 디렉토리 삭제 (-clear-cache)
 func removeDirForTest() {
 	myCache.Clear()
 	fmt.Println("Remove All cache")
 }
 
-func loadConfig() {
-	configData, err := os.ReadFile(CONFIG_PATH)
-	if err != nil {
-		panic(err)
-	}
-
-	newConfig := &ConfigStruct{}
-	err = json.Unmarshal(configData, newConfig)
-	if err != nil {
-		panic(err)
-	}
-	Config = *newConfig
-}
-
 func InitCache() {
-	switch Config.StoreType {
+	switch GetConfig().StoreType {
 	case STORE_TYPE_REDIS:
 		myCache = &cache.RedisCache{}
 	case STORE_TYPE_FILE:
-		myCache = &cache.FileCache{}
+		myCache = newFileCache()
+	case STORE_TYPE_MEMORY_REDIS:
+		myCache = newTieredCache(&cache.RedisCache{})
+	case STORE_TYPE_MEMORY_FILE:
+		myCache = newTieredCache(newFileCache())
 	default:
 		panic("StoreTypeError")
 	}
+
+	// 전체 용량 제한
+	if policyName := GetConfig().EvictionPolicy; policyName != "" {
+		policy, err := cache.NewEvictionPolicy(policyName)
+		if err != nil {
+			panic(err)
+		}
+		myCache = &cache.BoundedCache{
+			Backend:  myCache,
+			Policy:   policy,
+			MaxBytes: GetConfig().MaxCacheBytes,
+			MaxItems: GetConfig().MaxCacheItems,
+			OnEvict:  onCacheEvicted,
+		}
+	}
 	myCache.Init()
 }
 
+// 재시작하면 저장된 파일에서 캐시 목록을 다시 읽음. 지울 시각이 지난 캐시는 버림
+func newFileCache() *cache.FileCache {
+	return &cache.FileCache{
+		Dirs: getCacheDirs(),
+		IsRemovable: func(ci cache.CacheItem) bool {
+			return getRemoveTime(ci).Before(time.Now())
+		},
+	}
+}
+
+func getCacheDir(hc HostConfig) string {
+	if hc.CacheDir == "" {
+		return DEFAULT_CACHE_DIR
+	}
+	return hc.CacheDir
+}
+
+// 시작할 때 캐시 목록을 다시 읽을 Host들의 CacheDir. 기본 디렉토리는 항상 포함
+func getCacheDirs() []string {
+	dirs := []string{WCS_PATH + DEFAULT_CACHE_DIR}
+	for _, hc := range GetConfig().Hosts {
+		if dir := WCS_PATH + getCacheDir(hc); !slices.Contains(dirs, dir) {
+			dirs = append(dirs, dir)
+		}
+	}
+	return dirs
+}
+
+// 자주 쓰이는 캐시를 메모리에 두고, 메모리 계층이 가득 차면 LRU로 내보냄
+func newTieredCache(backend cache.Cache) *cache.TieredCache {
+	policy, _ := cache.NewEvictionPolicy(cache.POLICY_LRU)
+	memory := &cache.BoundedCache{
+		Backend:  &cache.MemoryCache{},
+		Policy:   policy,
+		MaxBytes: GetConfig().MemoryCacheBytes,
+		MaxItems: GetConfig().MemoryCacheItems,
+	}
+	return &cache.TieredCache{Memory: memory, Backend: backend}
+}
+
+// StoreType이 memory+file, memory+redis가 아니면 ok = false
+func getTieredCache() (tc *cache.TieredCache, ok bool) {
+	store := myCache
+	if bc, isBounded := store.(*cache.BoundedCache); isBounded {
+		store = bc.Backend
+	}
+	tc, ok = store.(*cache.TieredCache)
+	return tc, ok
+}
+
+func onCacheEvicted(cd cache.CacheData) {
+	if cd.Ci.PrimaryKey != "" {
+		unregisterVariant(cd.Ci.PrimaryKey, cd.Sha256)
+	}
+	myLogger.Debugf("Evicted) 캐시가 삭제되었습니다 : %s\n", cd.Ci.URL)
+}
+
+// 용량 제한이 없으면 ok = false
+func getCacheStats() (stats cache.CacheStats, ok bool) {
+	bc, ok := myCache.(*cache.BoundedCache)
+	if !ok {
+		return stats, false
+	}
+	return bc.Stats(), true
+}
+
 func InitWorkerpool() {
 	Workerpool = workerpool.NewWorkerPool(255)
 	Workerpool.Run()
 }
 
-func InitCountDatas() {
-	countData = countDatasForStatusPage{&sync.RWMutex{}, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}
-}
-
-func getReverseProxy(host string) *httputil.ReverseProxy {
-	url, err := url.Parse("http://" + host)
+func getReverseProxy(origin string) (*httputil.ReverseProxy, error) {
+	url, err := url.Parse(origin)
 	if err != nil {
-		panic(err)
+		return nil, err
+	}
+	if url.Scheme == "" || url.Host == "" {
+		return nil, fmt.Errorf("invalid origin %q", origin)
 	}
 	reverseProxy := httputil.NewSingleHostReverseProxy(url)
+	director := reverseProxy.Director
+	reverseProxy.Director = func(req *http.Request) {
+		director(req)
+		req.Host = url.Host
+	}
+	reverseProxy.Transport = originTransport{http.DefaultTransport}
 	reverseProxy.ModifyResponse = modifyResponse
-	return reverseProxy
+	reverseProxy.ErrorHandler = proxyErrorHandler
+	return reverseProxy, nil
 }
 
 func (ph *proxyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	reverseProxy, ok := ph.proxy[r.Host]
-	if !ok {
-		w.WriteHeader(404)
-		return
-	}
-
 	if r.Host == CUSTOM_HOST {
+		if !authorizeAdmin(w, r) {
+			return
+		}
 		switch r.URL.Path {
 		case "/statuspage":
 			showStatusPage(w, false)
@@ -237,78 +366,198 @@ func (ph *proxyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			showStatusPage(w, true)
 		case "/purge":
 			handlePurge(w, r)
+		case "/reload":
+			handleReload(w, r)
+		case "/metrics":
+			registry.ServeHTTP(w, r)
 		}
 		return
 	}
 
-	increaseRequestsCount(r.Host)
+	vhost, ok := getVirtualHost(r.Host)
+	if !ok {
+		w.WriteHeader(404)
+		return
+	}
 
 	uri := GetURI(r)
-	sha256 := GetSha256(uri)
-	hashKey := GetHashkey(uri)
+	state := &requestState{
+		vhost:      vhost,
+		host:       r.Host,
+		url:        "http://" + r.Host + r.URL.RequestURI(),
+		primaryURI: uri,
+		primaryKey: GetSha256(uri),
+		result:     RESULT_MISS,
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		state.result = RESULT_BYPASS
+	}
+	state.setKey(uri)
+	state.selectVariant(r.Header)
+	state.noCache = isValidationRequested(r.Header)
 
 	startTime := time.Now()
-	if isCacheExist(hashKey, sha256) {
-		responseByCacheItem(hashKey, sha256, w, r)
-		isCached = CACHED
+	cw := &countingResponseWriter{ResponseWriter: w}
+	w = cw
+	defer func() {
+		recordRequest(state, cw.written)
+		logAccess(state, r, cw, time.Since(startTime))
+	}()
+
+	cacheItem, body, exist := lookupCache(state)
+	defer func() { closeBody(body) }()
+	if exist && !isFresh(cacheItem) && isStaleServable(cacheItem, STALE_WHILE_REVALIDATE) {
+		state.result = RESULT_STALE
+		responseByCacheItem(cacheItem, body, state, w, r)
+		revalidateInBackground(cacheItem, state, r)
 	} else {
-		reverseProxy.ServeHTTP(w, r)
-		isCached = NOT_CACHED
+		if !(exist && state.isUsable(cacheItem)) && waitForFlight(state, r) {
+			closeBody(body)
+			// leader의 응답으로 Vary를 알게 됐을 수 있으므로 variant를 다시 고름
+			state.selectVariant(r.Header)
+			cacheItem, body, exist = lookupCache(state)
+		}
+		serveFromCacheOrOrigin(cacheItem, body, exist, state, w, r)
 	}
 
-	if Config.ResTimeLoggingEnabled {
+	if GetConfig().ResTimeLoggingEnabled {
 		elapsedTime := time.Since(startTime)
+		isCached := NOT_CACHED
+		if isHitResult(state.result) {
+			isCached = CACHED
+		}
 		myLogger.LogElapsedTime(r.Host+r.URL.Path+isCached, elapsedTime)
 	}
 }
 
+// 만료되지 않은 캐시는 본문을 읽지 않고 body로 돌려줌.
+// 만료된 캐시는 재검증이나 stale 응답에서 본문을 다시 쓰므로 ci.Body까지 읽어둠
+func lookupCache(state *requestState) (ci cache.CacheItem, body io.ReadCloser, exist bool) {
+	ci, body, exist = myCache.Open(state.hashKey, state.sha256)
+	if !exist || state.isUsable(ci) {
+		return ci, body, exist
+	}
+	ci.Body, _ = io.ReadAll(body)
+	body.Close()
+	return ci, io.NopCloser(bytes.NewReader(ci.Body)), exist
+}
+
+func closeBody(body io.ReadCloser) {
+	if body != nil {
+		body.Close()
+	}
+}
+
+func serveFromCacheOrOrigin(cacheItem cache.CacheItem, body io.Reader, exist bool, state *requestState, w http.ResponseWriter, r *http.Request) {
+	if exist && state.isUsable(cacheItem) {
+		state.result = RESULT_HIT
+		responseByCacheItem(cacheItem, body, state, w, r)
+	} else {
+		outReq := r.Clone(context.WithValue(r.Context(), requestStateKey{}, state))
+		state.clientReq = r
+		state.fwd = FWD_URI_MISS
+		if exist {
+			state.staleItem = &cacheItem
+			state.fwd = FWD_STALE
+			if isFresh(cacheItem) {
+				state.fwd = FWD_REQUEST
+			}
+		}
+		if state.result == RESULT_BYPASS {
+			state.fwd = FWD_BYPASS
+		}
+		// 저장할 수 있으면 전체를 받아서 캐시하고, Client에는 요청한 범위만 보냄.
+		// 아니면 Range를 그대로 보내고 Origin의 206을 전달
+		if isRangeFillable(r, state) {
+			state.rangeRequest = true
+			outReq.Header.Del("Range")
+			outReq.Header.Del("If-Range")
+		}
+		// 본문이 없는 HEAD 응답은 저장하지 않으므로, 캐시를 채우려면 GET으로 요청
+		if r.Method == http.MethodHead && GetConfig().HeadUpgradeEnabled {
+			state.headUpgrade = true
+			outReq.Method = http.MethodGet
+		}
+		if exist && hasValidator(cacheItem.Header) {
+			state.revalidating = true
+			SetConditionalHeaders(outReq, cacheItem.Header)
+		}
+		// 저장 작업이 있으면 저장이 끝난 후 기다리는 요청들을 깨움.
+		// Client 연결이 끊겨 proxy가 http.ErrAbortHandler로 panic해도 실행됨
+		defer func() {
+			if !state.cacheQueued {
+				state.finishFlight()
+			}
+		}()
+		state.vhost.proxy.ServeHTTP(w, outReq)
+	}
+}
+
+func getRequestState(r *http.Request) *requestState {
+	state, _ := r.Context().Value(requestStateKey{}).(*requestState)
+	return state
+}
+
 func modifyResponse(resp *http.Response) error {
-	url := resp.Request.URL
+	state := getRequestState(resp.Request)
+	if state == nil {
+		return nil
+	}
+	invalidateByUnsafeMethod(resp, state)
+	state.fwdStatus = resp.StatusCode
+	// 캐시로 바꾼 응답도 바뀐 뒤의 헤더에 씀
+	if !state.background {
+		defer func() {
+			removeInternalHeaders(resp.Header)
+			setCacheStatusHeader(resp.Header, state, state.clientReq)
+		}()
+	}
+	// 저장하지 않는 응답이면 기다리는 요청들을 바로 깨워 각자 Origin으로 보냄
+	filling := false
+	if state.rangeRequest {
+		defer func() { applyClientRange(resp, state, filling) }()
+	}
+	defer func() {
+		if !filling && !state.cacheQueued {
+			state.finishFlight()
+		}
+	}()
 
-	if !isCacheable(resp) {
+	if resp.StatusCode == http.StatusNotModified {
+		if state.revalidating {
+			refreshCacheItem(resp, state)
+		}
 		return nil
 	}
 
-	body, _ := io.ReadAll(resp.Body)
-	resp.Body = io.NopCloser(bytes.NewReader(body))
+	if serveStaleIfError(resp, state) {
+		return nil
+	}
 
-	// Unzip gzip
-	if url.Host == GLOBAL_HOST && resp.Header.Get("Content-Encoding") == GZIP {
-		body = GUnzip(body)
+	if !isCacheable(resp, state) {
+		return nil
 	}
-	defer resp.Body.Close()
 
-	// Check File Size
-	if len(body) > int(Config.MaxFileSize) {
-		myLogger.logger.Printf("File size over : %s (%d bytes)\n", url.String(), len(body))
-		increaseCountData(&countData.filesizeError)
+	// Check File Size. 압축을 푼 크기는 압축된 크기보다 작지 않으므로 Content-Length만으로 판단 가능.
+	// 모르는 경우는 받으면서 확인
+	if resp.ContentLength > GetConfig().MaxFileSize {
+		myLogger.Warnf("File size over : %s (%d bytes)\n", state.url, resp.ContentLength)
+		increaseNotCached(state, REASON_FILE_SIZE)
 		return nil
 	}
 
 	contentType := resp.Header.Get("Content-Type")
-	myLogger.logger.Printf("Content-Type : %s, %s\n", contentType, url)
+	myLogger.Debugf("Content-Type : %s, %s\n", contentType, state.url)
 
-	Workerpool.AddTask(func() { CacheFile(body, resp) })
+	filling = startCacheFill(resp, state)
 
-	return nil
-}
-
-func increaseRequestsCount(host string) {
-	switch host {
-	case GLOBAL_HOST:
-		increaseCountData(&countData.gRequest)
-	case IMAGE_HOST:
-		increaseCountData(&countData.iRequest)
-	}
-}
-
-func increaseHitCount(host string) {
-	switch host {
-	case GLOBAL_HOST:
-		increaseCountData(&countData.gHit, &countData.sendCache)
-	case IMAGE_HOST:
-		increaseCountData(&countData.iHit, &countData.sendCache)
+	// Client에는 본문을 보내지 않으므로 여기서 끝까지 받아 저장
+	if state.headUpgrade {
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		resp.Body = http.NoBody
 	}
+	return nil
 }
 
 func showStatusPage(w http.ResponseWriter, showImage bool) {
@@ -320,19 +569,30 @@ func showStatusPage(w http.ResponseWriter, showImage bool) {
 		return math.Round(perFloat*100) / 100
 	}
 
-	countData.rwMutex.RLock()
-	gPercent := getPercent(countData.gHit, countData.gRequest)
-	iPercent := getPercent(countData.iHit, countData.iRequest)
-	tPercent := getPercent(countData.gHit+countData.iHit, countData.gRequest+countData.iRequest)
-
-	htmlDataList := []htmlHitData{
-		{"Global", countData.gHit, countData.gRequest, gPercent},
-		{"Image", countData.iHit, countData.iRequest, iPercent},
-		{"Total", countData.gHit + countData.iHit, countData.gRequest + countData.iRequest, tPercent},
+	// /metrics와 같은 값을 Host별로 보여줌
+	hits, requests := map[string]int{}, map[string]int{}
+	requestsTotal.Each(func(labelValues []string, value float64) {
+		host, result := labelValues[0], labelValues[1]
+		requests[host] += int(value)
+		if isHitResult(result) {
+			hits[host] += int(value)
+		}
+	})
+	htmlDataList := []htmlHitData{}
+	totalHit, totalRequests := 0, 0
+	for _, hc := range GetConfig().Hosts {
+		hit, req := hits[hc.Host], requests[hc.Host]
+		htmlDataList = append(htmlDataList, htmlHitData{hc.Host, hit, req, getPercent(hit, req)})
+		totalHit, totalRequests = totalHit+hit, totalRequests+req
 	}
-	countData.rwMutex.RUnlock()
+	htmlDataList = append(htmlDataList, htmlHitData{"Total", totalHit, totalRequests, getPercent(totalHit, totalRequests)})
+
+	notModifiedCount := int(notModifiedTotal.Sum(nil))
+	staleCount := int(requestsTotal.Sum(func(labelValues []string) bool { return labelValues[1] == RESULT_STALE }))
+	invalidatedCount := int(invalidatedTotal.Sum(nil))
 
 	configDataList := []htmlConfigData{}
+	configLoadedTime := getConfigLoadedTime()
 	configData := htmlConfigData{}
 	for key, value := range getConfigDatas() {
 		val := fmt.Sprintf("%v", value)
@@ -344,14 +604,34 @@ func showStatusPage(w http.ResponseWriter, showImage bool) {
 		return configDataList[i].Name < configDataList[j].Name
 	})
 
+	getReasonCount := func(reason string) int {
+		return int(notCachedTotal.Sum(func(labelValues []string) bool { return labelValues[1] == reason }))
+	}
 	rnc := htmlReasonsNotCached{
-		countData.filesizeError,
-		countData.cacheException,
-		countData.statusError,
-		countData.methodError,
-		countData.cacheControlError,
-		countData.contentTypeError,
-		countData.filesizeError + countData.cacheException + countData.statusError + countData.methodError + countData.cacheControlError + countData.contentTypeError,
+		getReasonCount(REASON_FILE_SIZE),
+		getReasonCount(REASON_CACHE_EXCEPTION),
+		getReasonCount(REASON_STATUS),
+		getReasonCount(REASON_METHOD),
+		getReasonCount(REASON_CACHE_CONTROL),
+		getReasonCount(REASON_CONTENT_TYPE),
+		getReasonCount(REASON_VARY),
+		int(notCachedTotal.Sum(nil)),
+	}
+
+	usage := htmlCacheUsage{Policy: GetConfig().EvictionPolicy}
+	if stats, ok := getCacheStats(); ok {
+		usage = htmlCacheUsage{true, usage.Policy, stats.Items, stats.MaxItems, stats.UsedBytes, stats.MaxBytes, stats.Evictions}
+	}
+
+	tierHits := htmlTierHits{}
+	if tc, ok := getTieredCache(); ok {
+		tierStats := tc.Stats()
+		memoryStats := tc.Memory.(*cache.BoundedCache).Stats()
+		tierHits = htmlTierHits{
+			true, strings.TrimPrefix(GetConfig().StoreType, "memory+"),
+			tierStats.MemoryHits, tierStats.BackendHits, tierStats.Misses,
+			memoryStats.Items, memoryStats.UsedBytes, memoryStats.Evictions,
+		}
 	}
 
 	tmpl, err := template.ParseFiles(WCS_PATH + "status-page.html")
@@ -359,15 +639,16 @@ func showStatusPage(w http.ResponseWriter, showImage bool) {
 		panic(err)
 	}
 
-	htmlData := HTMLData{htmlDataList, configDataList, getCachedData(showImage), rnc}
+	htmlData := HTMLData{htmlDataList, configDataList, configLoadedTime.Format(time.DateTime), notModifiedCount, staleCount, invalidatedCount, getCachedData(showImage), rnc, usage, tierHits}
 	err = tmpl.Execute(w, htmlData)
 	if err != nil {
 		panic(err)
 	}
 }
 
+// 파일이 아닌 현재 적용 중인 Config를 보여줌
 func getConfigDatas() map[string]interface{} {
-	file, err := os.ReadFile(CONFIG_PATH)
+	file, err := json.Marshal(GetConfig())
 	if err != nil {
 		panic(err)
 	}
@@ -377,85 +658,112 @@ func getConfigDatas() map[string]interface{} {
 	if err != nil {
 		panic(err)
 	}
+	// 인증 정보는 보여주지 않음
+	delete(data, "AdminTokens")
+	delete(data, "AdminUsers")
 
 	return data
 }
 
-func handlePurge(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodDelete {
-		return
-	}
-	pattern := r.URL.Query().Get("pattern")
-	compiledPattern, err := regexp.Compile(pattern)
-	if err != nil {
-		myLogger.logger.Printf("정규 표현식 컴파일 오류: %s (%v)\n", pattern, err)
-		w.WriteHeader(400)
-		return
-	}
-
-	matchCount := 0
-
-	cacheDataList := myCache.GetAll()
-	for _, cd := range cacheDataList {
-		if compiledPattern.MatchString(cd.Ci.URL) {
-			removeCacheFile(cd.HashKey, cd.Sha256, cd.Ci.URL, "Purge")
-			matchCount += 1
-		}
-	}
-	fmt.Fprintf(w, "Purge Success! (%d items)\n", matchCount)
-}
-
 func getCachedData(showImage bool) (cachedData htmlCacheData) {
 	cachedData.ShowImage = showImage
 
+	// 같은 URL의 variant들은 한 항목으로 묶어서 보여줌
+	imageVariants := map[string]int{}
+	globalVariants := map[string]int{}
 	cacheDataList := myCache.GetAll()
 	for _, cd := range cacheDataList {
-		switch cd.Ci.Host {
-		case IMAGE_HOST:
-			cachedData.ImageData = append(cachedData.ImageData, cd.Ci.URL)
-			cachedData.ImageDataCount += 1
-		default:
-			cachedData.GlobalData = append(cachedData.GlobalData, cd.Ci.URL)
-			cachedData.GlobalDataCount += 1
+		if strings.HasPrefix(cd.Ci.Header.Get("Content-Type"), "image/") {
+			imageVariants[cd.Ci.URL] += 1
+		} else {
+			globalVariants[cd.Ci.URL] += 1
 		}
 	}
 
-	sort.Slice(cachedData.ImageData, func(i, j int) bool {
-		return cachedData.ImageData[i] < cachedData.ImageData[j]
-	})
-	sort.Slice(cachedData.GlobalData, func(i, j int) bool {
-		return cachedData.GlobalData[i] < cachedData.GlobalData[j]
-	})
+	toCachedURLs := func(variants map[string]int) []htmlCachedURL {
+		cachedURLs := []htmlCachedURL{}
+		for url, count := range variants {
+			cachedURLs = append(cachedURLs, htmlCachedURL{url, count})
+		}
+		sort.Slice(cachedURLs, func(i, j int) bool {
+			return cachedURLs[i].URL < cachedURLs[j].URL
+		})
+		return cachedURLs
+	}
+	cachedData.ImageData = toCachedURLs(imageVariants)
+	cachedData.ImageDataCount = len(cachedData.ImageData)
+	cachedData.GlobalData = toCachedURLs(globalVariants)
+	cachedData.GlobalDataCount = len(cachedData.GlobalData)
 
-	length := len(cachedData.ImageData)
+	images := []string{}
+	for _, cachedURL := range cachedData.ImageData {
+		images = append(images, cachedURL.URL)
+	}
+	length := len(images)
 	length /= 3
-	cachedData.Images1 = cachedData.ImageData[:length]
-	cachedData.Images2 = cachedData.ImageData[length : length*2]
-	cachedData.Images3 = cachedData.ImageData[length*2:]
+	cachedData.Images1 = images[:length]
+	cachedData.Images2 = images[length : length*2]
+	cachedData.Images3 = images[length*2:]
 
 	return cachedData
 }
 
-func responseByCacheItem(hashKey int, sha256 string, w http.ResponseWriter, r *http.Request) {
-	cacheItem, _ := myCache.Get(hashKey, sha256)
-	filebody := cacheItem.Body
+// 본문은 body에서 읽음. Range나 Gzip 응답처럼 본문 전체가 필요한 경우에만 모두 읽어서 cacheItem.Body에 둠
+func responseByCacheItem(cacheItem cache.CacheItem, body io.Reader, state *requestState, w http.ResponseWriter, r *http.Request) {
+	state.expirationTime = cacheItem.ExpirationTime
+	setCacheStatusHeader(w.Header(), state, r)
+	if IsNotModified(r.Header, cacheItem.Header) {
+		responseNotModified(cacheItem, w)
+		notModifiedTotal.With(state.host).Inc()
+		return
+	}
 
-	if Config.GzipEnabled && getIsGzipAccepted(r) {
-		filebody = GZip(filebody)
-		w.Header().Set("Content-Encoding", GZIP)
+	setHitHeader(w.Header(), cacheItem)
+	encodings := getEncodings(cacheItem.Header, state.vhost)
+	if len(encodings) > 0 {
+		addVary(w.Header(), "Accept-Encoding")
 	}
+	w.Header().Set("Accept-Ranges", "bytes")
+	w.Header().Add("jnlee", "HIT")
 
-	setHeaderFromCache := func(headerKey string) {
-		w.Header().Set(headerKey, cacheItem.Header.Get(headerKey))
+	if r.Header.Get("Range") != "" && cacheItem.Body == nil {
+		cacheItem.Body, _ = io.ReadAll(body)
+		body = bytes.NewReader(cacheItem.Body)
+	}
+	if responseRange(cacheItem, w, r) {
+		return
 	}
-	setHeaderFromCache("Cache-Control")
-	setHeaderFromCache("Etag")
 
-	w.Header().Set("Age", strconv.Itoa(int(time.Since(cacheItem.CachedTime).Seconds())))
-	w.Header().Add("jnlee", "HIT")
-	w.Write(filebody)
+	encoding := SelectEncoding(r.Header.Get("Accept-Encoding"), encodings)
+	if r.Method == http.MethodHead {
+		responseHead(cacheItem, encoding, w)
+		return
+	}
+	if encoding != "" {
+		responseEncoded(cacheItem, body, encoding, state, w)
+		return
+	}
+	if cacheItem.Body != nil {
+		cacheItem.Size = int64(len(cacheItem.Body))
+	}
+	w.Header().Set("Content-Length", strconv.FormatInt(cacheItem.Size, 10))
+	io.Copy(w, body)
+}
 
-	increaseHitCount(r.Host)
+// GET으로 저장된 캐시의 헤더만 보냄. 보내면서 압축하는 경우는 크기를 모르므로 Content-Length 없음
+func responseHead(cacheItem cache.CacheItem, encoding string, w http.ResponseWriter) {
+	if cacheItem.Body != nil {
+		cacheItem.Size = int64(len(cacheItem.Body))
+	}
+	if encoding == "" {
+		w.Header().Set("Content-Length", strconv.FormatInt(cacheItem.Size, 10))
+	} else {
+		w.Header().Set("Content-Encoding", encoding)
+		if size, ok := cacheItem.EncodedSizes[encoding]; ok {
+			w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+		}
+	}
+	w.WriteHeader(http.StatusOK)
 }
 
 func GZip(data []byte) []byte {
@@ -466,21 +774,23 @@ func GZip(data []byte) []byte {
 	return buf.Bytes()
 }
 
-func GUnzip(data []byte) []byte {
+func GUnzip(data []byte) ([]byte, error) {
 	reader, err := gzip.NewReader(bytes.NewReader(data))
 	if err != nil {
-		panic(err)
-	}
-	body, err := io.ReadAll(reader)
-	if err != nil {
-		panic(err)
+		return nil, err
 	}
 	defer reader.Close()
-	return body
+	return io.ReadAll(reader)
 }
 
+// HEAD는 GET과 같은 캐시를 사용하므로 GET으로 만듦
 func GetURI(req *http.Request) string {
+	config := GetConfig()
 	myUrl := req.URL
+	method := req.Method
+	if method == http.MethodHead {
+		method = http.MethodGet
+	}
 	host := func() string {
 		if len(myUrl.Host) == 0 {
 			return req.Host
@@ -490,9 +800,9 @@ func GetURI(req *http.Request) string {
 	}()
 
 	switch {
-	case len(myUrl.Query()) == 0 || Config.QueryIgnoreEnabled:
-		return req.Method + host + myUrl.Path
-	case Config.QuerySortingEnabled:
+	case len(myUrl.Query()) == 0 || config.QueryIgnoreEnabled:
+		return method + host + myUrl.Path
+	case config.QuerySortingEnabled:
 		var keys []string
 		for key := range myUrl.Query() {
 			keys = append(keys, key)
@@ -506,7 +816,7 @@ func GetURI(req *http.Request) string {
 				}
 			}
 		}
-		return req.Method + host + myUrl.Path + "?" + sortedQuery.Encode()
+		return method + host + myUrl.Path + "?" + sortedQuery.Encode()
 	default:
 		queries := strings.Split(myUrl.RawQuery, "&")
 		var result []string
@@ -516,22 +826,12 @@ func GetURI(req *http.Request) string {
 				result = append(result, fmt.Sprintf("%s=%s", parts[0], parts[1]))
 			}
 		}
-		return req.Method + host + myUrl.Path + "?" + strings.Join(result, "&")
+		return method + host + myUrl.Path + "?" + strings.Join(result, "&")
 	}
 }
 
 func IsCacheException(url string) bool {
-	regexps := make([]*regexp.Regexp, len(Config.CacheExceptions))
-	for i, pattern := range Config.CacheExceptions {
-		compiledPattern, err := regexp.Compile(pattern)
-		if err != nil {
-			myLogger.logger.Printf("정규 표현식 컴파일 오류: %s (%v)\n", pattern, err)
-			continue
-		}
-		regexps[i] = compiledPattern
-	}
-
-	for _, r := range regexps {
+	for _, r := range live.Load().cacheExceptions {
 		if r.MatchString(url) {
 			return true
 		}
@@ -539,49 +839,65 @@ func IsCacheException(url string) bool {
 	return false
 }
 
-func isCacheExist(hashKey int, sha256 string) bool {
-	_, exist := myCache.Get(hashKey, sha256)
-	return exist
-}
-
 // StatueCode, Method, Cache-Control, Content-Type 확인
-func isCacheable(resp *http.Response) bool {
-	url := resp.Request.URL
-	uri := GetURI(resp.Request)
+func isCacheable(resp *http.Response, state *requestState) bool {
+	url := state.url
+	uri := state.primaryURI
 
 	if IsCacheException(uri) {
-		increaseCountData(&countData.cacheException)
-		myLogger.logger.Printf("CheckCacheable : CacheException. uri = %s\n", uri)
+		increaseNotCached(state, REASON_CACHE_EXCEPTION)
+		myLogger.Debugf("CheckCacheable : CacheException. uri = %s\n", uri)
 		return false
 	}
 
 	//Check Status Code
 	if resp.StatusCode != http.StatusOK {
-		myLogger.logger.Printf("CheckCacheable : Status not ok. StatusCode = %d, %s\n", resp.StatusCode, url)
-		increaseCountData(&countData.statusError)
+		myLogger.Debugf("CheckCacheable : Status not ok. StatusCode = %d, %s\n", resp.StatusCode, url)
+		increaseNotCached(state, REASON_STATUS)
 		return false
 	}
 
-	//Check Method
-	if resp.Request.Method != http.MethodGet && resp.Request.Method != http.MethodHead {
-		increaseCountData(&countData.methodError)
-		myLogger.logger.Printf("CheckCacheable : Method not ok. method = %s\n", resp.Request.Method)
+	//Check Method. HEAD 응답은 본문이 없으므로 저장하지 않음
+	if resp.Request.Method != http.MethodGet {
+		increaseNotCached(state, REASON_METHOD)
+		myLogger.Debugf("CheckCacheable : Method not ok. method = %s\n", resp.Request.Method)
 		return false
 	}
 
 	//Check Cache Control
-	cacheControl := resp.Header.Get("Cache-Control")
+	cacheControl := strings.Join(resp.Header.Values("Cache-Control"), ",")
 	if !IsCacheControlSaveAllowed(cacheControl) {
-		myLogger.logger.Printf("CheckCacheable : Cache-Control Not Allowed (%s) : %s\n", cacheControl, url)
-		increaseCountData(&countData.cacheControlError)
+		myLogger.Debugf("CheckCacheable : Cache-Control Not Allowed (%s) : %s\n", cacheControl, url)
+		increaseNotCached(state, REASON_CACHE_CONTROL)
+		return false
+	}
+
+	//Check Authorization. 인증된 요청의 응답은 Origin이 공유를 허용한 경우만 저장 (RFC 9111 3.5)
+	if resp.Request.Header.Get("Authorization") != "" && !IsAuthorizedSaveAllowed(cacheControl) {
+		myLogger.Debugf("CheckCacheable : Authorization without public, s-maxage, must-revalidate (%s) : %s\n", cacheControl, url)
+		increaseNotCached(state, REASON_AUTHORIZATION)
 		return false
 	}
 
 	//Check Content Type
 	contentType := resp.Header.Get("Content-Type")
 	if !IsContentTypeSaveAllowed(contentType) {
-		myLogger.logger.Printf("CheckCacheable : Cache save not allowd by Content-Type (%s) : %s\n", contentType, url)
-		increaseCountData(&countData.contentTypeError)
+		myLogger.Debugf("CheckCacheable : Cache save not allowd by Content-Type (%s) : %s\n", contentType, url)
+		increaseNotCached(state, REASON_CONTENT_TYPE)
+		return false
+	}
+
+	//Check Content-Encoding. gzip만 압축을 풀어서 저장하므로 br, zstd 등은 저장하지 않음
+	if encoding := resp.Header.Get("Content-Encoding"); encoding != "" && encoding != GZIP && encoding != "identity" {
+		myLogger.Debugf("CheckCacheable : Content-Encoding not supported (%s) : %s\n", encoding, url)
+		increaseNotCached(state, REASON_ENCODING)
+		return false
+	}
+
+	//Check Vary
+	if _, isAny := ParseVary(resp.Header); isAny {
+		myLogger.Debugf("CheckCacheable : Vary is * : %s\n", url)
+		increaseNotCached(state, REASON_VARY)
 		return false
 	}
 
@@ -589,15 +905,23 @@ func isCacheable(resp *http.Response) bool {
 }
 
 func IsCacheControlSaveAllowed(cacheControl string) bool {
-	notAllowed := []string{"no-store", "no-cache", "proxy-revalidate", "private"}
-	for _, n := range notAllowed {
-		if strings.Contains(cacheControl, n) {
-			return false
-		}
+	cc := ParseCacheControl(cacheControl)
+	if cc.Has("no-store") || cc.Has("proxy-revalidate") {
+		return false
+	}
+	// no-cache="Set-Cookie"처럼 헤더가 지정된 경우 해당 헤더만 빼고 저장
+	if cc.isUnqualified("no-cache") || cc.isUnqualified("private") {
+		return false
 	}
 	return true
 }
 
+// Authorization 헤더가 있는 요청의 응답을 저장해도 되는지
+func IsAuthorizedSaveAllowed(cacheControl string) bool {
+	cc := ParseCacheControl(cacheControl)
+	return cc.Has("public") || cc.Has("s-maxage") || cc.Has("must-revalidate")
+}
+
 func IsContentTypeSaveAllowed(contentType string) bool {
 	allowed := []string{"text/", "image/"}
 	for _, n := range allowed {
@@ -608,72 +932,60 @@ func IsContentTypeSaveAllowed(contentType string) bool {
 	return false
 }
 
-func CacheFile(body []byte, resp *http.Response) {
-	uri := GetURI(resp.Request)
-	sha256 := GetSha256(uri)
-	hashKey := GetHashkey(uri)
-	ci := cache.CacheItem{
-		Header:         resp.Header,
-		Body:           body,
-		URL:            resp.Request.URL.String(),
-		Host:           resp.Request.Host,
-		ExpirationTime: GetExpirationTime(resp.Header.Get("Cache-Control")),
-		CachedTime:     time.Now(),
-	}
-
-	switch Config.StoreType {
-	case STORE_TYPE_FILE:
-		if resp.Request.URL.Host == IMAGE_HOST {
-			ci.Filepath = WCS_PATH + "log_image/" + sha256
-		} else {
-			ci.Filepath = WCS_PATH + "log_body/" + sha256
-		}
-	}
-	myCache.Set(hashKey, sha256, ci)
-
-	increaseCountData(&countData.cachedFile)
-}
-
-func GetExpirationTime(cacheControl string) time.Time {
-	var exTime time.Time
+func cleanupExpiredCaches(ctx context.Context) {
+	frequency := GetConfig().CleanupFrequency
+	ticker := time.NewTicker(time.Second * time.Duration(frequency))
+	defer ticker.Stop()
 
-	if cacheControl != "" {
-		matches := regexp.MustCompile(`max-age=(\d+)`).FindStringSubmatch(cacheControl)
-		if len(matches) > 1 {
-			maxAgeInt, _ := strconv.Atoi(matches[1])
-			cTime := time.Now()
-			exTime = cTime.Add(time.Duration(maxAgeInt) * time.Second)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-cleanupResetC:
+			// Config reload로 CleanupFrequency가 바뀐 경우 주기만 다시 설정
+			if newFrequency := GetConfig().CleanupFrequency; newFrequency != frequency {
+				frequency = newFrequency
+				ticker.Reset(time.Second * time.Duration(frequency))
+				myLogger.Infof("Cleanup frequency changed : %ds\n", frequency)
+			}
+			continue
 		}
-	}
-	return exTime
-}
-
-func cleanupExpiredCaches() {
-	ticker := time.NewTicker(time.Second * time.Duration(Config.CleanupFrequency))
-	defer ticker.Stop()
 
-	for range ticker.C {
 		cacheDataList := myCache.GetAll()
 		for _, cd := range cacheDataList {
-			if cd.Ci.ExpirationTime.Before(time.Now()) {
-				removeCacheFile(cd.HashKey, cd.Sha256, cd.Ci.URL, "Expired")
+			if getRemoveTime(cd.Ci).Before(time.Now()) {
+				removeCacheFile(cd, "Expired")
 			}
 		}
-		myLogger.logger.Printf("Cleanup Expired Items\n")
+		myLogger.Infof("Cleanup Expired Items\n")
 	}
 }
 
-func removeCacheFile(hashKey int, sha256 string, url string, logMsg string) {
-	myCache.Del(hashKey, sha256)
-	myLogger.logger.Printf("%s) 캐시가 삭제되었습니다 : %s\n", logMsg, url)
+func removeCacheFile(cd cache.CacheData, logMsg string) {
+	myCache.Del(cd.HashKey, cd.Sha256)
+	if cd.Ci.PrimaryKey != "" {
+		unregisterVariant(cd.Ci.PrimaryKey, cd.Sha256)
+	}
+	myLogger.Infof("%s) 캐시가 삭제되었습니다 : %s\n", logMsg, cd.Ci.URL)
 }
 
-func logPerSec() {
+// 1초 동안 저장한 캐시 수와 캐시로 응답한 수
+func logPerSec(ctx context.Context) {
 	ticker := time.NewTicker(time.Second)
 	defer ticker.Stop()
 
-	for range ticker.C {
-		myLogger.LogCacheNum()
+	lastCached, lastSent := 0.0, 0.0
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		cached := cachedTotal.Sum(nil)
+		sent := requestsTotal.Sum(func(labelValues []string) bool { return isHitResult(labelValues[1]) })
+		myLogger.LogCacheNum(int(cached-lastCached), int(sent-lastSent))
+		lastCached, lastSent = cached, sent
 	}
 }
 
@@ -693,45 +1005,3 @@ func GetHashkey(uri string) int {
 	}
 	return sha256Int % 255
 }
-
-func getIsGzipAccepted(r *http.Request) bool {
-	return strings.Contains(r.Header.Get("Accept-Encoding"), GZIP) && r.Host != IMAGE_HOST
-}
-
-func openLoggerFile(fName string) *os.File {
-	logFile, err := os.OpenFile(fName, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
-	if err != nil {
-		panic(err)
-	}
-	return logFile
-}
-
-func generateLogger(f *os.File) *MyLogger {
-	logger := &MyLogger{log.New(f, "\n", log.Ldate|log.Ltime)}
-	return logger
-}
-
-func (mLogger *MyLogger) LogElapsedTime(url string, elapsedTime time.Duration) {
-	var sb strings.Builder
-	fmt.Fprintf(&sb, "ResponseTime : %s, %s", url, elapsedTime)
-	mLogger.logger.Println(sb.String())
-}
-
-func (mLogger *MyLogger) LogCacheNum() {
-	countData.rwMutex.Lock()
-	var sb strings.Builder
-	fmt.Fprintf(&sb, "Cached File Number = %d, Send cache file number = %d", countData.cachedFile, countData.sendCache)
-	mLogger.logger.Println(sb.String())
-
-	countData.cachedFile = 0
-	countData.sendCache = 0
-	countData.rwMutex.Unlock()
-}
-
-func increaseCountData(targets ...*int) {
-	countData.rwMutex.Lock()
-	defer countData.rwMutex.Unlock()
-	for _, target := range targets {
-		*target += 1
-	}
-}
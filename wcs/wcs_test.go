@@ -1,12 +1,18 @@
 package wcs_test
 
 import (
+	"bytes"
 	"fmt"
+	"io"
 	"jnlee/wcs"
 	"jnlee/workerpool"
 	"math/rand"
+	"mime"
+	"mime/multipart"
 	"net/http"
 	"net/url"
+	"sort"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -15,10 +21,22 @@ import (
 var (
 	MockedConfig = ConfigMock{
 		c: wcs.ConfigStruct{
-			100000, true, []string{
+			MaxFileSize: 100000,
+			GzipEnabled: true,
+			CacheExceptions: []string{
 				"cache-exception",
 				"/2016/",
-			}, false, true, true, 60, "file",
+			},
+			QueryIgnoreEnabled:    false,
+			QuerySortingEnabled:   true,
+			ResTimeLoggingEnabled: true,
+			CleanupFrequency:      60,
+			HeuristicFreshPercent: 10,
+			StoreType:             "file",
+			Hosts: []wcs.HostConfig{
+				{Host: "global.gmarket.co.kr", Origin: "http://global.gmarket.co.kr", GzipEnabled: true},
+				{Host: "image.gmarket.co.kr", Origin: "http://image.gmarket.co.kr", GzipEnabled: false},
+			},
 		},
 	}
 	// dummyFileData []byte
@@ -29,7 +47,10 @@ type ConfigMock struct {
 }
 
 func init() {
-	wcs.Config = MockedConfig.c
+	err := wcs.SetConfig(MockedConfig.c)
+	if err != nil {
+		panic(err)
+	}
 
 	// str := "abcdefghij"
 	// strR := strings.Repeat(str, 200)
@@ -97,12 +118,22 @@ func TestGetHashKey(t *testing.T) {
 
 func TestIsCacheSaveAllowed(t *testing.T) {
 	dummy := map[string]bool{
-		"no-store 123123":        false,
-		"public, max-age=604800": true,
-		"no-cache 12":            false,
-		"12 proxy-revalidate":    false,
-		"12 proxy-revalidaaate":  true,
-		"private":                false,
+		"no-store 123123":                        false,
+		"public, max-age=604800":                 true,
+		"no-cache 12":                            false,
+		"12 proxy-revalidate":                    false,
+		"12 proxy-revalidaaate":                  true,
+		"private":                                false,
+		`max-age=60, community="no-cache-ish"`:   true,
+		`no-cache="Set-Cookie"`:                  true,
+		`private="Set-Cookie, X-Token"`:          true,
+		"max-age=60, NO-STORE":                   false,
+		"must-revalidate, max-age=0":             true,
+		"public, immutable, max-age=31536000":    true,
+		"max-age=600, stale-while-revalidate=30": true,
+		"no-transform":                           true,
+		"no-store-ish":                           true,
+		"":                                       true,
 	}
 
 	for key, val := range dummy {
@@ -114,6 +145,215 @@ func TestIsCacheSaveAllowed(t *testing.T) {
 	}
 }
 
+func TestIsAuthorizedSaveAllowed(t *testing.T) {
+	dummy := map[string]bool{
+		"max-age=60":                  false,
+		"public, max-age=60":          true,
+		"s-maxage=60":                 true,
+		"must-revalidate, max-age=60": true,
+		`community="public"`:          false,
+		"":                            false,
+	}
+
+	for key, val := range dummy {
+		if ans := wcs.IsAuthorizedSaveAllowed(key); ans != val {
+			fmt.Printf("key = %s, ans = %t\n", key, ans)
+			t.Error("WrongResult")
+		}
+	}
+}
+
+func TestParseCacheControl(t *testing.T) {
+	dummy := map[string]wcs.CacheControl{
+		"public, max-age=604800":                          {"public": "", "max-age": "604800"},
+		`max-age=60, community="no-cache-ish"`:            {"max-age": "60", "community": "no-cache-ish"},
+		`no-cache="Set-Cookie, X-Token", must-revalidate`: {"no-cache": "Set-Cookie, X-Token", "must-revalidate": ""},
+		`private="a\"b", Max-Age=1`:                       {"private": `a"b`, "max-age": "1"},
+		"max-age=1, max-age=2,,":                          {"max-age": "1"},
+		"stale-while-revalidate=30,stale-if-error=86400 ": {"stale-while-revalidate": "30", "stale-if-error": "86400"},
+		"": {},
+	}
+
+	for key, val := range dummy {
+		ans := wcs.ParseCacheControl(key)
+		if fmt.Sprint(ans) != fmt.Sprint(val) {
+			fmt.Printf("key = %s, ans = %v\n", key, ans)
+			t.Error("WrongResult")
+		}
+	}
+
+	cc := wcs.ParseCacheControl(`no-cache="set-cookie, x-token", max-age=abc`)
+	if fmt.Sprint(cc.FieldNames("no-cache")) != "[Set-Cookie X-Token]" {
+		t.Error("WrongResult")
+	}
+	if _, ok := cc.Seconds("max-age"); ok {
+		t.Error("WrongResult")
+	}
+}
+
+func TestParseVary(t *testing.T) {
+	dummy := map[*http.Header]string{
+		{"Vary": {"Accept-Encoding"}}:                            "[Accept-Encoding] false",
+		{"Vary": {"accept-encoding, Accept-Language", "Accept"}}: "[Accept-Encoding Accept-Language Accept] false",
+		{"Vary": {"Accept, accept"}}:                             "[Accept] false",
+		{"Vary": {"Accept-Encoding, *"}}:                         "[] true",
+		{}:                                                       "[] false",
+	}
+
+	for key, val := range dummy {
+		vary, isAny := wcs.ParseVary(*key)
+		ans := fmt.Sprint(vary, " ", isAny)
+		if ans != val {
+			fmt.Printf("key = %v, ans = %s\n", *key, ans)
+			t.Error("WrongResult")
+		}
+	}
+}
+
+func TestParseAcceptEncoding(t *testing.T) {
+	dummy := map[string]string{
+		"gzip, br":                       "map[br:1 gzip:1]",
+		"GZIP;q=0.5, br;Q=0.8, zstd;q=0": "map[br:0.8 gzip:0.5 zstd:0]",
+		"gzip;q=2, br;q=abc, *;q=0.1":    "map[*:0.1]",
+		"gzip;q=0.3, gzip":               "map[gzip:0.3]",
+		"":                               "map[]",
+	}
+
+	for key, val := range dummy {
+		ans := fmt.Sprint(wcs.ParseAcceptEncoding(key))
+		if ans != val {
+			fmt.Printf("key = %s, ans = %s\n", key, ans)
+			t.Error("WrongResult")
+		}
+	}
+}
+
+func TestSelectEncoding(t *testing.T) {
+	encodings := []string{"br", "zstd", "gzip"}
+	dummy := map[string]string{
+		"":                        "",
+		"gzip":                    "gzip",
+		"gzip, deflate, br, zstd": "br",
+		"br;q=0.5, gzip;q=0.8":    "gzip",
+		"gzip;q=0.5":              "gzip",
+		"gzip;q=0":                "",
+		"identity":                "",
+		"gzip;q=0.5, identity":    "",
+		"*":                       "br",
+		"*;q=0.5, br;q=0":         "zstd",
+		"*;q=0":                   "",
+		"deflate":                 "",
+	}
+
+	for key, val := range dummy {
+		ans := wcs.SelectEncoding(key, encodings)
+		if ans != val {
+			fmt.Printf("key = %s, ans = %s\n", key, ans)
+			t.Error("WrongResult")
+		}
+	}
+}
+
+func TestGetVariantURI(t *testing.T) {
+	uri := "GETimage.gmarket.co.kr/a.jpg"
+	vary := []string{"Accept", "Accept-Language"}
+	webp := wcs.GetVariantURI(uri, vary, http.Header{"Accept": {"image/webp, */*"}})
+	webp2 := wcs.GetVariantURI(uri, vary, http.Header{"Accept": {"image/webp,*/*"}, "User-Agent": {"a"}})
+	jpeg := wcs.GetVariantURI(uri, vary, http.Header{"Accept": {"image/jpeg"}})
+
+	if webp != webp2 || webp == jpeg || wcs.GetSha256(webp) == wcs.GetSha256(uri) {
+		t.Error("WrongResult")
+	}
+	if wcs.GetVariantURI(uri, nil, http.Header{"Accept": {"image/jpeg"}}) != uri {
+		t.Error("WrongResult")
+	}
+}
+
+func TestParseRange(t *testing.T) {
+	dummy := map[string]string{
+		"bytes=0-99":            "[{0 99}] <nil>",
+		"bytes=100-":            "[{100 999}] <nil>",
+		"bytes=-50":             "[{950 999}] <nil>",
+		"bytes=-5000":           "[{0 999}] <nil>",
+		"bytes=900-1500":        "[{900 999}] <nil>",
+		"bytes=0-0, -1":         "[{0 0} {999 999}] <nil>",
+		"bytes=0-9,2000-,20-29": "[{0 9} {20 29}] <nil>",
+		"bytes=1000-":           "[] unsatisfiable range",
+		"bytes=-0":              "[] unsatisfiable range",
+		"bytes=10-5":            "[] invalid range",
+		"bytes=abc":             "[] invalid range",
+		"items=0-10":            "[] invalid range",
+		"bytes=":                "[] invalid range",
+		"bytes= , ":             "[] invalid range",
+		"bytes=0-99,50-149":     "[] invalid range",
+		"bytes=0-4,5-9":         "[{0 4} {5 9}] <nil>",
+		"bytes=" + strings.Repeat("0-0,", wcs.MAX_RANGES) + "1-1": "[] invalid range",
+	}
+
+	for key, val := range dummy {
+		ranges, err := wcs.ParseRange(key, 1000)
+		ans := fmt.Sprint(ranges, " ", err)
+		if ans != val {
+			fmt.Printf("key = %s, ans = %s\n", key, ans)
+			t.Error("WrongResult")
+		}
+	}
+}
+
+func TestIsIfRangeMatched(t *testing.T) {
+	stored := http.Header{
+		"Etag":          {`"abc"`},
+		"Last-Modified": {"Mon, 20 Nov 2023 10:00:00 GMT"},
+	}
+	dummy := map[string]bool{
+		"":                              true,
+		`"abc"`:                         true,
+		`W/"abc"`:                       false,
+		`"xyz"`:                         false,
+		"Mon, 20 Nov 2023 10:00:00 GMT": true,
+		"Tue, 21 Nov 2023 10:00:00 GMT": false,
+	}
+
+	for key, val := range dummy {
+		ans := wcs.IsIfRangeMatched(key, stored)
+		if ans != val {
+			fmt.Printf("key = %s, ans = %t\n", key, ans)
+			t.Error("WrongResult")
+		}
+	}
+}
+
+func TestGetRangeContent(t *testing.T) {
+	body := []byte("0123456789")
+
+	header, rangeBody := wcs.GetRangeContent(body, "text/plain", []wcs.ByteRange{{2, 4}})
+	if string(rangeBody) != "234" || header.Get("Content-Range") != "bytes 2-4/10" || header.Get("Content-Type") != "text/plain" {
+		t.Error("WrongResult")
+	}
+
+	header, rangeBody = wcs.GetRangeContent(body, "text/plain", []wcs.ByteRange{{0, 1}, {8, 9}})
+	mediaType, params, _ := mime.ParseMediaType(header.Get("Content-Type"))
+	if mediaType != "multipart/byteranges" {
+		t.Error("WrongResult")
+	}
+	reader := multipart.NewReader(bytes.NewReader(rangeBody), params["boundary"])
+	expected := map[string]string{"bytes 0-1/10": "01", "bytes 8-9/10": "89"}
+	for {
+		part, err := reader.NextPart()
+		if err != nil {
+			break
+		}
+		partBody, _ := io.ReadAll(part)
+		if expected[part.Header.Get("Content-Range")] != string(partBody) {
+			t.Error("WrongResult")
+		}
+		delete(expected, part.Header.Get("Content-Range"))
+	}
+	if len(expected) != 0 {
+		t.Error("Missing part")
+	}
+}
+
 func TestIsContentTypeSaveAllowed(t *testing.T) {
 	dummy := map[string]bool{
 		"application/json 12314":        false,
@@ -147,12 +387,16 @@ func TestGzipDecompress(t *testing.T) {
 	for _, val := range dummy {
 		b := []byte(val)
 		af := wcs.GZip(b)
-		be := wcs.GUnzip(af)
+		be, err := wcs.GUnzip(af)
 
-		if len(b) != len(be) {
+		if err != nil || len(b) != len(be) {
 			t.Error("Wrong")
 		}
 	}
+
+	if _, err := wcs.GUnzip([]byte("not gzip")); err == nil {
+		t.Error("Invalid gzip accepted")
+	}
 }
 
 func TestIsCacheException(t *testing.T) {
@@ -190,6 +434,10 @@ func TestGetURI(t *testing.T) {
 			URL:    url3,
 			Method: http.MethodGet,
 		}: "GETimage.gmarket.co.kr/service_image/2023/10/27/20231027174714148076_0_0.jpg",
+		&http.Request{
+			URL:    url3,
+			Method: http.MethodHead,
+		}: "GETimage.gmarket.co.kr/service_image/2023/10/27/20231027174714148076_0_0.jpg",
 	}
 
 	for key, val := range dummy {
@@ -203,18 +451,211 @@ func TestGetURI(t *testing.T) {
 
 func TestGetExpirationTime(t *testing.T) {
 	now := time.Now()
+	hostConfig := wcs.HostConfig{}
 	dummy := map[string]time.Time{
 		"private, max-age=3600":                           now.Add(time.Second * 3600),
 		"private, max-age=900":                            now.Add(time.Second * 900),
 		"public,max-age=1200,stale-while-revalidate=3600": now.Add(time.Second * 1200),
-		"no-cache": time.Time{},
+		"no-cache": now,
 	}
 	for key, val := range dummy {
-		hk := wcs.GetExpirationTime(key)
+		hk := wcs.GetExpirationTime(http.Header{"Cache-Control": {key}}, hostConfig, now)
 		if hk.Sub(val) > time.Millisecond {
 			t.Error("Wrong")
 		}
 	}
+
+	// Age만큼 빨리 만료
+	header := http.Header{"Cache-Control": {"max-age=600"}, "Age": {"100"}}
+	if !wcs.GetExpirationTime(header, hostConfig, now).Equal(now.Add(time.Second * 500)) {
+		t.Error("Wrong")
+	}
+}
+
+func TestGetFreshnessLifetime(t *testing.T) {
+	now := time.Date(2023, 11, 20, 10, 0, 0, 0, time.UTC)
+	hostConfig := wcs.HostConfig{DefaultTTL: 30}
+	dummy := map[*http.Header]time.Duration{
+		{"Cache-Control": {"max-age=60, s-maxage=120"}}:                                           time.Second * 120,
+		{"Cache-Control": {"public, max-age=60"}}:                                                 time.Second * 60,
+		{"Cache-Control": {"max-age=60"}, "Expires": {"Mon, 20 Nov 2023 12:00:00 GMT"}}:           time.Second * 60,
+		{"Date": {"Mon, 20 Nov 2023 10:00:00 GMT"}, "Expires": {"Mon, 20 Nov 2023 11:00:00 GMT"}}: time.Hour,
+		{"Date": {"Mon, 20 Nov 2023 10:00:00 GMT"}, "Expires": {"0"}}:                             0,
+		{"Last-Modified": {"Fri, 10 Nov 2023 10:00:00 GMT"}}:                                      time.Hour * 24,
+		{"Cache-Control": {"public"}}:                                                             time.Second * 30,
+	}
+
+	for key, val := range dummy {
+		ans := wcs.GetFreshnessLifetime(*key, hostConfig, now)
+		if ans != val {
+			fmt.Printf("key = %v, ans = %s\n", *key, ans)
+			t.Error("WrongResult")
+		}
+	}
+
+	// HeuristicFreshnessPercent가 0이면 Last-Modified가 있어도 DefaultTTL
+	defer wcs.SetConfig(*wcs.GetConfig())
+	noHeuristicConfig := *wcs.GetConfig()
+	noHeuristicConfig.HeuristicFreshPercent = 0
+	if err := wcs.SetConfig(noHeuristicConfig); err != nil {
+		t.Fatal(err)
+	}
+	header := http.Header{"Last-Modified": {"Fri, 10 Nov 2023 10:00:00 GMT"}}
+	if ans := wcs.GetFreshnessLifetime(header, hostConfig, now); ans != time.Second*30 {
+		t.Errorf("HeuristicFreshnessPercent 0 : %s", ans)
+	}
+}
+
+func TestSetConfig(t *testing.T) {
+	defer wcs.SetConfig(MockedConfig.c)
+
+	invalidConfig := MockedConfig.c
+	invalidConfig.CacheExceptions = []string{"("}
+	if wcs.SetConfig(invalidConfig) == nil {
+		t.Error("Invalid regex accepted")
+	}
+	if wcs.IsCacheException("/2016/") != true {
+		t.Error("Previous config not kept")
+	}
+
+	invalidConfig = MockedConfig.c
+	invalidConfig.Hosts = []wcs.HostConfig{{Host: "a.co.kr", Origin: "a.co.kr"}}
+	if wcs.SetConfig(invalidConfig) == nil {
+		t.Error("Origin without scheme accepted")
+	}
+
+	invalidConfig.Hosts = []wcs.HostConfig{{Host: "a.co.kr", Origin: "http://a.co.kr", CacheDir: "../log_body"}}
+	if wcs.SetConfig(invalidConfig) == nil {
+		t.Error("CacheDir outside WCS_PATH accepted")
+	}
+
+	newConfig := MockedConfig.c
+	newConfig.CacheExceptions = []string{"/2017/"}
+	if wcs.SetConfig(newConfig) != nil {
+		t.Error("Valid config rejected")
+	}
+	if wcs.IsCacheException("/2016/") || !wcs.IsCacheException("/2017/") {
+		t.Error("New config not applied")
+	}
+}
+
+func TestSetConditionalHeaders(t *testing.T) {
+	dummy := map[string]http.Header{
+		`"abc"`:                         {"Etag": {`"abc"`}},
+		"Mon, 20 Nov 2023 10:00:00 GMT": {"Last-Modified": {"Mon, 20 Nov 2023 10:00:00 GMT"}},
+		"":                              {},
+	}
+
+	for val, storedHeader := range dummy {
+		req, _ := http.NewRequest(http.MethodGet, "http://global.gmarket.co.kr/", nil)
+		req.Header.Set("If-None-Match", `"client"`)
+		wcs.SetConditionalHeaders(req, storedHeader)
+		ans := req.Header.Get("If-None-Match") + req.Header.Get("If-Modified-Since")
+		if ans != val {
+			fmt.Printf("val = %s, ans = %s\n", val, ans)
+			t.Error("WrongResult")
+		}
+	}
+}
+
+func TestMergeNotModifiedHeader(t *testing.T) {
+	stored := http.Header{
+		"Cache-Control":  {"max-age=10"},
+		"Content-Length": {"100"},
+		"Content-Type":   {"text/html"},
+	}
+	notModified := http.Header{
+		"Cache-Control":  {"max-age=60"},
+		"Content-Length": {"0"},
+	}
+
+	merged := wcs.MergeNotModifiedHeader(stored, notModified)
+	if merged.Get("Cache-Control") != "max-age=60" || merged.Get("Content-Length") != "100" || merged.Get("Content-Type") != "text/html" {
+		t.Error("WrongResult")
+	}
+	if stored.Get("Cache-Control") != "max-age=10" {
+		t.Error("Stored header modified")
+	}
+}
+
+func TestGetReplayHeader(t *testing.T) {
+	stored := http.Header{
+		"Cache-Control":     {"max-age=60"},
+		"Connection":        {"X-Conn-Only"},
+		"X-Conn-Only":       {"1"},
+		"Keep-Alive":        {"timeout=5"},
+		"Transfer-Encoding": {"chunked"},
+		"Age":               {"30"},
+		"Content-Length":    {"100"},
+		"Content-Type":      {"text/html"},
+		"Set-Cookie":        {"a=1"},
+	}
+
+	dummy := map[string][]string{
+		"Cache-Control,Content-Type,Set-Cookie": nil,
+		"Cache-Control,Content-Type":            {"set-cookie"},
+		"Content-Type":                          {"Set-Cookie", "Age"},
+	}
+	for ans, denyList := range dummy {
+		allowList := []string{}
+		if ans == "Content-Type" {
+			allowList = []string{"content-type", "Age"}
+		}
+		header := wcs.GetReplayHeader(stored, allowList, denyList)
+		keys := []string{}
+		for key := range header {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		if val := strings.Join(keys, ","); val != ans {
+			fmt.Printf("val = %s, ans = %s\n", val, ans)
+			t.Error("WrongResult")
+		}
+	}
+}
+
+func TestGetSurrogateKeys(t *testing.T) {
+	dummy := map[string]string{
+		"":                      "",
+		"product":               "product",
+		" product  category-1 ": "product,category-1",
+	}
+
+	for key, ans := range dummy {
+		header := http.Header{"Surrogate-Key": {key}}
+		val := strings.Join(wcs.GetSurrogateKeys(header), ",")
+		if val != ans {
+			fmt.Printf("val = %s, ans = %s\n", val, ans)
+			t.Error("WrongResult")
+		}
+	}
+}
+
+func TestIsNotModified(t *testing.T) {
+	stored := http.Header{
+		"Etag":          {`W/"abc"`},
+		"Last-Modified": {"Mon, 20 Nov 2023 10:00:00 GMT"},
+	}
+	dummy := map[*http.Header]bool{
+		{"If-None-Match": {`"abc"`}}:                             true,
+		{"If-None-Match": {`"x", W/"abc"`}}:                      true,
+		{"If-None-Match": {"*"}}:                                 true,
+		{"If-None-Match": {`"x"`}}:                               false,
+		{"If-Modified-Since": {"Mon, 20 Nov 2023 10:00:00 GMT"}}: true,
+		{"If-Modified-Since": {"Tue, 21 Nov 2023 10:00:00 GMT"}}: true,
+		{"If-Modified-Since": {"Sun, 19 Nov 2023 10:00:00 GMT"}}: false,
+		{"If-Modified-Since": {"invalid date"}}:                  false,
+		{}:                                                       false,
+		{"If-None-Match": {`"x"`}, "If-Modified-Since": {"Tue, 21 Nov 2023 10:00:00 GMT"}}: false,
+	}
+
+	for key, val := range dummy {
+		ans := wcs.IsNotModified(*key, stored)
+		if ans != val {
+			fmt.Printf("key = %v, ans = %t\n", *key, ans)
+			t.Error("WrongResult")
+		}
+	}
 }
 
 // var (
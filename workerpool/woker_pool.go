@@ -1,13 +1,31 @@
 package workerpool
 
+import (
+	"sync"
+	"sync/atomic"
+)
+
 type WorkerPool interface {
 	Run()
-	AddTask(task func())
+	// Close된 뒤에는 작업을 받지 않고 false
+	AddTask(task func()) bool
+	// 빈 worker를 기다리고 있는 작업 수
+	QueueDepth() int
+	// 추가된 작업이 모두 끝날 때까지 기다림
+	Wait()
+	// 새 작업을 받지 않고, 추가된 작업이 모두 끝날 때까지 기다림
+	Close()
 }
 
 type workerPool struct {
 	maxWorker   int
 	queuedTaskC chan func()
+	waiting     atomic.Int64
+
+	mutex   sync.Mutex
+	done    *sync.Cond // pending이 0이 되면 깨움
+	pending int        // 추가된 후 아직 끝나지 않은 작업
+	closed  bool
 }
 
 func NewWorkerPool(maxWorker int) WorkerPool {
@@ -15,6 +33,7 @@ func NewWorkerPool(maxWorker int) WorkerPool {
 		maxWorker:   maxWorker,
 		queuedTaskC: make(chan func()),
 	}
+	wp.done = sync.NewCond(&wp.mutex)
 	return wp
 }
 
@@ -22,8 +41,50 @@ func (wp *workerPool) Run() {
 	wp.run()
 }
 
-func (wp *workerPool) AddTask(task func()) {
-	wp.queuedTaskC <- task
+func (wp *workerPool) AddTask(task func()) bool {
+	wp.mutex.Lock()
+	if wp.closed {
+		wp.mutex.Unlock()
+		return false
+	}
+	wp.pending++
+	wp.mutex.Unlock()
+
+	wp.waiting.Add(1)
+	defer wp.waiting.Add(-1)
+	wp.queuedTaskC <- func() {
+		defer wp.taskDone()
+		task()
+	}
+	return true
+}
+
+func (wp *workerPool) taskDone() {
+	wp.mutex.Lock()
+	defer wp.mutex.Unlock()
+	wp.pending--
+	if wp.pending == 0 {
+		wp.done.Broadcast()
+	}
+}
+
+func (wp *workerPool) QueueDepth() int {
+	return int(wp.waiting.Load())
+}
+
+func (wp *workerPool) Wait() {
+	wp.mutex.Lock()
+	defer wp.mutex.Unlock()
+	for wp.pending > 0 {
+		wp.done.Wait()
+	}
+}
+
+func (wp *workerPool) Close() {
+	wp.mutex.Lock()
+	wp.closed = true
+	wp.mutex.Unlock()
+	wp.Wait()
 }
 
 func (wp *workerPool) GetTotalQueuedTask() int {